@@ -1,22 +1,31 @@
 package controllers
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
 	"net/http"
-	"os/exec"
 	"strconv"
 	"time"
 
 	"github.com/go-pg/pg/v9"
 	"github.com/labstack/echo/v4"
 	"github.com/xiorcale/rubus-api/models"
+	"github.com/xiorcale/rubus-api/provisioning"
 	"github.com/xiorcale/rubus-api/services"
 	"gopkg.in/ini.v1"
 )
 
 // AdminController -
 type AdminController struct {
-	DB  *pg.DB
-	Cfg *ini.File
+	DB          *pg.DB
+	Cfg         *ini.File
+	Providers   *services.ProviderRegistry
+	Syncer      *services.Syncer
+	Jobs        *services.JobRunner
+	Provisioner *provisioning.Provisioner
+	Queue       *services.WaitQueue
 }
 
 // CreateUser -
@@ -71,7 +80,7 @@ func (a *AdminController) ListUser(c echo.Context) error {
 }
 
 // DeleteUser -
-// @description Delete the `User` with the given id
+// @description Delete the `User` with the given id, along with their SSH keys, notifications and snapshots. The devices they own are released, shut down and granted to the users waiting for them, and their waiting queue entries and reservations are canceled.
 // @id deleteUser
 // @tags admin
 // @summary Delete a user
@@ -86,7 +95,7 @@ func (a *AdminController) DeleteUser(c echo.Context) error {
 
 	id, _ := strconv.Atoi(c.Param("id"))
 
-	if jsonErr := models.DeleteUser(a.DB, int64(id)); jsonErr != nil {
+	if jsonErr := services.DeleteUser(a.DB, a.Providers, a.Provisioner, a.Queue, int64(id)); jsonErr != nil {
 		return echo.NewHTTPError(jsonErr.Status, jsonErr)
 	}
 
@@ -136,8 +145,121 @@ func (a *AdminController) UpdateUserExpiration(c echo.Context) error {
 	return c.JSON(http.StatusOK, user)
 }
 
+// GetUserQuota -
+// @description Return the `Quota` of the `User` with the given id, along with its usage.
+// @id getUserQuota
+// @tags admin
+// @summary get the quota of a user
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `User`"
+// @success 200 {object} models.QuotaUsage
+// @router /admin/user/{id}/quota [get]
+func (a *AdminController) GetUserQuota(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	usage, jsonErr := services.GetQuotaUsage(a.DB, a.Cfg, int64(id))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, usage)
+}
+
+// UpdateUserQuota -
+// @description Override the `Quota` of the role of the `User` with the given id. A null limit falls back to the quota of the user's role, and a limit of 0 means unlimited.
+// @id updateUserQuota
+// @tags admin
+// @summary override the quota of a user
+// @accept json
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `User`"
+// @param RequestBody body models.PutQuota true "The limits overriding the quota of the user's role"
+// @success 200 {object} models.QuotaUsage
+// @router /admin/user/{id}/quota [put]
+func (a *AdminController) UpdateUserQuota(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	putQuota := models.PutQuota{}
+	if err := c.Bind(&putQuota); err != nil ||
+		(putQuota.MaxDevices != nil && *putQuota.MaxDevices < 0) ||
+		(putQuota.MaxHoursPerWeek != nil && *putQuota.MaxHoursPerWeek < 0) {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	user, jsonErr := models.GetUser(a.DB, int64(id))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	user.MaxDevices = putQuota.MaxDevices
+	user.MaxHoursPerWeek = putQuota.MaxHoursPerWeek
+
+	if jsonErr := models.UpdateUserQuota(a.DB, user); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	usage, jsonErr := services.GetQuotaUsage(a.DB, a.Cfg, user.ID)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, usage)
+}
+
+// ListUserDevice -
+// @description List the devices currently owned by the `User` with the given id.
+// @id listUserDevice
+// @tags admin
+// @summary list the devices owned by a user
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `User`"
+// @success 200 {array} models.Device "A JSON array listing the devices"
+// @router /admin/user/{id}/device [get]
+func (a *AdminController) ListUserDevice(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if _, jsonErr := models.GetUser(a.DB, int64(id)); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	devices, jsonErr := models.GetDevicesByOwner(a.DB, int64(id))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, devices)
+}
+
 // CreateDevice -
-// @description Add a `Device` into the database and prepare the necessary directory structure for deploying it.
+// @description Add a `Device` into the database and prepare the necessary directory structure for deploying it. The hostname names the directories of the device, so it must be unique, must not end with `-work`, and must not name the directory of an image or a template. The directory structure is prepared in the background, its progress can be followed through the returned `Job`. If it cannot be prepared, the device is removed.
 // @id createDevice
 // @tags admin
 // @accept json
@@ -145,7 +267,9 @@ func (a *AdminController) UpdateUserExpiration(c echo.Context) error {
 // @security jwt
 // @param hostname query string true "The hostname of the device"
 // @param port query string true "The device's switch port"
-// @success 201 {object} models.Device
+// @param provider query string false "The name of the provider the device is plugged into (default: `default`)"
+// @param address query string false "The provider-specific address of the device, if it differs from the port"
+// @success 202 {object} models.Job
 // @router /admin/device [post]
 func (a *AdminController) CreateDevice(c echo.Context) error {
 	if jsonErr := FilterAdmin(c); jsonErr != nil {
@@ -154,16 +278,42 @@ func (a *AdminController) CreateDevice(c echo.Context) error {
 
 	hostname := c.QueryParam("hostname")
 	port := c.QueryParam("port")
+	address := c.QueryParam("address")
+	providerName := c.QueryParam("provider")
+	if providerName == "" {
+		providerName = services.DefaultProviderName
+	}
 
-	// setup the necessary files and folders for the network boot and deployment
-	cmd := exec.Command("./scripts/add-device.sh", hostname)
-	go cmd.Run()
+	portNumber, err := strconv.ParseInt(port, 10, 64)
+	if err != nil || hostname == "" {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := a.checkHostname(hostname); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	provider, jsonErr := a.Providers.Get(providerName)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if address == "" {
+		address = port
+	}
 
 	// retrieve the device state
-	device, jsonErr := services.GetDevice(port)
+	device, jsonErr := provider.GetDevice(address)
 	if jsonErr != nil {
 		return echo.NewHTTPError(jsonErr.Status, jsonErr)
 	}
+	device.Hostname = hostname
+	device.Provider = providerName
+	device.Port = portNumber
+	if address != port {
+		device.Address = address
+	}
 
 	// "cache" the device by inserting it into the
 	// database for faster read requests
@@ -171,19 +321,287 @@ func (a *AdminController) CreateDevice(c echo.Context) error {
 		return echo.NewHTTPError(jsonErr.Status, jsonErr)
 	}
 
-	return c.JSON(http.StatusCreated, device)
+	// setup the necessary files and folders for the network boot and deployment
+	job := models.Job{
+		Type:        models.EnumJobCreateDevice,
+		DeviceID:    &device.ID,
+		RequesterID: ExtractIDFromToken(c),
+	}
+
+	if jsonErr := a.Jobs.Submit(&job, services.CreateDeviceTask(a.DB, a.Provisioner, device)); jsonErr != nil {
+		models.DeleteDevice(a.DB, device.ID)
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// DiscoverDevice -
+// @description List every device reported by a provider, and tell which of them are already registered (`registered`) and which are new.
+// @id discoverDevice
+// @tags admin
+// @summary Discover the devices of a provider
+// @produce json
+// @security jwt
+// @param provider query string false "The name of the provider to discover (default: `default`)"
+// @success 200 {array} models.DiscoveredDevice
+// @router /admin/device/discover [get]
+func (a *AdminController) DiscoverDevice(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	providerName := c.QueryParam("provider")
+	if providerName == "" {
+		providerName = services.DefaultProviderName
+	}
+
+	discovered, jsonErr := services.Discover(a.DB, a.Providers, providerName)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, discovered)
+}
+
+// ImportDevice -
+// @description Register the new devices reported by a provider and prepare the directory structure for deploying each of them, in a single transaction: if any of them fails, none is imported, and only the directories created by the import are removed. The hostnames reported by the provider must be valid for `createDevice`.
+// @id importDevice
+// @tags admin
+// @summary Import discovered devices
+// @accept json
+// @produce json
+// @security jwt
+// @param RequestBody body models.ImportDevices true "The `provider` defaults to `default`. If `ports` is empty, every new device is imported."
+// @success 201 {array} models.Device
+// @router /admin/device/import [post]
+func (a *AdminController) ImportDevice(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	importDevices := models.ImportDevices{}
+	if err := c.Bind(&importDevices); err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if importDevices.Provider == "" {
+		importDevices.Provider = services.DefaultProviderName
+	}
+
+	discovered, jsonErr := services.Discover(a.DB, a.Providers, importDevices.Provider)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	selected := map[int64]bool{}
+	for _, port := range importDevices.Ports {
+		selected[port] = true
+	}
+
+	devices := []models.Device{}
+	for _, d := range discovered {
+		if len(selected) > 0 && !selected[d.Port] {
+			continue
+		}
+		delete(selected, d.Port)
+
+		if d.Registered {
+			continue
+		}
+
+		if jsonErr := a.checkHostname(d.Hostname); jsonErr != nil {
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+
+		devices = append(devices, models.Device{
+			Hostname:   d.Hostname,
+			Provider:   d.Provider,
+			Port:       d.Port,
+			PowerState: d.PowerState,
+		})
+	}
+
+	if len(selected) > 0 {
+		jsonErr := models.JSONError{
+			Status: http.StatusNotFound,
+			Error:  "some ports are not reported by the provider.",
+		}
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	// setup the necessary files and folders for the network boot and
+	// deployment of each device, and undo them if the import is rolled back.
+	// Only the directories created by this import are removed.
+	setupDone := []string{}
+	outputs := []string{}
+	setup := func(d *models.Device) *models.JSONError {
+		provisioned, err := a.Provisioner.Provisioned(d.Hostname)
+		if err != nil {
+			return &models.JSONError{
+				Status: http.StatusInternalServerError,
+				Error:  "could not setup device " + d.Hostname + ": " + err.Error(),
+			}
+		}
+
+		output := &bytes.Buffer{}
+		err = services.SetupDeviceTask(a.Provisioner, d.Hostname)(context.Background(), output)
+		if !provisioned {
+			setupDone = append(setupDone, d.Hostname)
+		}
+		if err != nil {
+			return &models.JSONError{
+				Status: http.StatusInternalServerError,
+				Error:  "could not setup device " + d.Hostname + ": " + err.Error() + "\n" + output.String(),
+			}
+		}
+		outputs = append(outputs, output.String())
+		return nil
+	}
+
+	if jsonErr := models.AddDeviceMulti(a.DB, &devices, setup); jsonErr != nil {
+		for _, hostname := range setupDone {
+			a.Provisioner.DeleteDevice(context.Background(), hostname, ioutil.Discard)
+		}
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	// keep the output of each setup along with the device
+	for i := range devices {
+		job := models.Job{
+			Type:        models.EnumJobCreateDevice,
+			DeviceID:    &devices[i].ID,
+			RequesterID: ExtractIDFromToken(c),
+		}
+		a.Jobs.Record(&job, outputs[i], nil)
+	}
+
+	return c.JSON(http.StatusCreated, devices)
+}
+
+// UpdateDeviceLocation -
+// @description Move the `Device` with the given id to another provider and/or port (e.g. after re-cabling it). The device keeps its id, owner and history.
+// @id updateDeviceLocation
+// @tags admin
+// @summary Move a device to another port
+// @accept json
+// @produce json
+// @security jwt
+// @param id path int64 true "The id of the device to move"
+// @param RequestBody body models.PutDevice true "The new location of the device. Only the given fields are updated."
+// @success 200 {object} models.Device
+// @router /admin/device/{id} [put]
+func (a *AdminController) UpdateDeviceLocation(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	putDevice := models.PutDevice{}
+	if err := c.Bind(&putDevice); err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	device, jsonErr := models.GetDevice(a.DB, int64(id))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if putDevice.Provider != nil {
+		device.Provider = *putDevice.Provider
+	}
+	if putDevice.Port != nil {
+		device.Port = *putDevice.Port
+	}
+	if putDevice.Address != nil {
+		device.Address = *putDevice.Address
+	}
+
+	// make sure the new location exists on the provider
+	provider, jsonErr := a.Providers.Get(device.Provider)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if _, jsonErr := provider.GetDevice(device.ProviderAddress()); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := models.UpdateDeviceLocation(a.DB, device); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, device)
+}
+
+// UpdateDeviceLabels -
+// @description Describe the `Device` with the given id with `tags`, a `model` and a `pool`, which can be used to acquire devices by criteria. The `pool` must be an existing `DevicePool`, an empty one removing the device from its pool.
+// @id updateDeviceLabels
+// @tags admin
+// @summary Describe a device
+// @accept json
+// @produce json
+// @security jwt
+// @param id path int64 true "The id of the device to describe"
+// @param RequestBody body models.PutDeviceLabels true "The labels of the device. Only the given fields are updated."
+// @success 200 {object} models.Device
+// @router /admin/device/{id}/labels [put]
+func (a *AdminController) UpdateDeviceLabels(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	putLabels := models.PutDeviceLabels{}
+	if err := c.Bind(&putLabels); err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	device, jsonErr := models.GetDevice(a.DB, int64(id))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if putLabels.Tags != nil {
+		device.Tags = *putLabels.Tags
+	}
+	if putLabels.Model != nil {
+		device.Model = *putLabels.Model
+	}
+	if putLabels.Pool != nil {
+		device.Pool = *putLabels.Pool
+	}
+
+	if jsonErr := models.UpdateDeviceLabels(a.DB, device); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, device)
 }
 
 // DeleteDevice -
-// @description Delete a `Device` from the database and remove its directory structure used for deployment.
+// @description Delete a `Device` from the database and remove its directory structure used for deployment. The deletion runs in the background, its progress can be followed through the returned `Job`.
 // @id deleteDevice
 // @tags admin
 // @summary Delete a device
 // @produce json
 // @security jwt
-// @param hostname query string true "The hostname of the device"
-// @param deviceId query int64 true "The device's switch port"
-// @success 204
+// @param hostname query string false "The hostname of the device, defaults to the hostname stored in the database"
+// @param deviceId query int64 true "The id of the device"
+// @success 202 {object} models.Job
 // @router /admin/device [delete]
 func (a *AdminController) DeleteDevice(c echo.Context) error {
 	if jsonErr := FilterAdmin(c); jsonErr != nil {
@@ -197,14 +615,368 @@ func (a *AdminController) DeleteDevice(c echo.Context) error {
 		return echo.NewHTTPError(jsonErr.Status, jsonErr)
 	}
 
+	device, jsonErr := models.GetDevice(a.DB, int64(deviceID))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if hostname == "" {
+		hostname = device.Hostname
+	}
+
 	// delete the necessary files and folders
 	// for the network boot and deployment
-	cmd := exec.Command("./scripts/delete-device.sh", hostname)
-	cmd.Run()
+	job := models.Job{
+		Type:        models.EnumJobDeleteDevice,
+		DeviceID:    &device.ID,
+		RequesterID: ExtractIDFromToken(c),
+	}
 
-	if jsonErr := models.DeleteDevice(a.DB, int64(deviceID)); err != nil {
+	task := services.DeleteDeviceTask(a.DB, a.Provisioner, device, hostname)
+	if jsonErr := a.Jobs.Submit(&job, task); jsonErr != nil {
 		return echo.NewHTTPError(jsonErr.Status, jsonErr)
 	}
 
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// ListProvider -
+// @description Return a list containing all the registered providers, either defined in the configuration file (`static`) or registered through the API.
+// @id listProvider
+// @tags admin
+// @summary List all the providers
+// @produce json
+// @security jwt
+// @success 200 {array} services.ProviderInfo "A JSON array listing all the providers"
+// @router /admin/provider [get]
+func (a *AdminController) ListProvider(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, a.Providers.List())
+}
+
+// CreateProvider -
+// @description Register a new provider implementing the Rubus Provider API. Timeout and TLS settings are taken from the `[provider]` section of the configuration file.
+// @id createProvider
+// @tags admin
+// @summary Register a new provider
+// @accept json
+// @produce json
+// @security jwt
+// @param RequestBody body models.NewProvider true "The `name` and `baseUrl` are required, the `token` is optional."
+// @success 201 {object} models.Provider
+// @router /admin/provider [post]
+func (a *AdminController) CreateProvider(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	newProvider := models.NewProvider{}
+	if err := c.Bind(&newProvider); err != nil || newProvider.Name == "" || newProvider.BaseURL == "" {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if _, jsonErr := a.Providers.Get(newProvider.Name); jsonErr == nil {
+		jsonErr := models.JSONError{
+			Status: http.StatusConflict,
+			Error:  "provider already exists.",
+		}
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	provider := models.Provider{
+		Name:    newProvider.Name,
+		BaseURL: newProvider.BaseURL,
+		Token:   newProvider.Token,
+	}
+
+	defaults := services.ReadHTTPProviderConfig(a.Cfg.Section("provider"))
+	if err := a.Providers.RegisterProvider(defaults, &provider); err != nil {
+		jsonErr := models.JSONError{
+			Status: http.StatusBadRequest,
+			Error:  err.Error(),
+		}
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := models.AddProvider(a.DB, &provider); jsonErr != nil {
+		a.Providers.Unregister(provider.Name)
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusCreated, provider)
+}
+
+// DeleteProvider -
+// @description Unregister the provider with the given name. Providers defined in the configuration file or still used by some devices cannot be deleted.
+// @id deleteProvider
+// @tags admin
+// @summary Delete a provider
+// @produce json
+// @security jwt
+// @param name path string true "The name of the provider to delete"
+// @success 204
+// @router /admin/provider/{name} [delete]
+func (a *AdminController) DeleteProvider(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	name := c.Param("name")
+
+	for _, info := range a.Providers.List() {
+		if info.Name == name && info.Static {
+			jsonErr := models.JSONError{
+				Status: http.StatusBadRequest,
+				Error:  "provider is defined in the configuration file.",
+			}
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+	}
+
+	if jsonErr := models.DeleteProvider(a.DB, name); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	a.Providers.Unregister(name)
+
 	return c.NoContent(http.StatusNoContent)
 }
+
+// GetSyncReport -
+// @description Return the time of the last synchronization between the database and the providers, along with the drift it found: devices whose state changed, registered devices the provider no longer reports, and devices reported by a provider but not registered yet.
+// @id getSyncReport
+// @tags admin
+// @summary Get the last synchronization report
+// @produce json
+// @security jwt
+// @success 200 {object} services.SyncReport
+// @router /admin/sync [get]
+func (a *AdminController) GetSyncReport(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, a.Syncer.Report())
+}
+
+// Sync -
+// @description Synchronize the database with the providers right away, and return the resulting report.
+// @id sync
+// @tags admin
+// @summary Synchronize the devices with the providers
+// @produce json
+// @security jwt
+// @success 200 {object} services.SyncReport
+// @router /admin/sync [post]
+func (a *AdminController) Sync(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, a.Syncer.Sync())
+}
+
+// ForceReleaseDevice -
+// @description Release the `Device` with the given id on behalf of its owner, for instance when it is stuck. The lease of the owner ends as `revoked` with the given reason, which is also sent to the owner in a `device-revoked` notification. Depending on the `wipe_on_release` policy, the device can also be shut down and its overlay reset to its pristine state, in which case the wipe runs in the background and the returned `Job` tracks its progress: the device stays leased until the wipe succeeds. The device is then granted to the first user waiting for it in the queue.
+// @id forceReleaseDevice
+// @tags admin
+// @summary force the release of a device
+// @accept json
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `Device` to release"
+// @param RequestBody body models.DeviceRevocation true "Why the device is released"
+// @param wipe query bool false "Wipe the device (only if the policy is `optional`)"
+// @success 200 {object} models.Device
+// @success 202 {object} models.Job
+// @router /admin/device/{id}/release [post]
+func (a *AdminController) ForceReleaseDevice(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	revocation := models.DeviceRevocation{}
+	if err := c.Bind(&revocation); err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if revocation.Reason == "" {
+		jsonErr := models.JSONError{
+			Status: http.StatusBadRequest,
+			Error:  "reason is required.",
+		}
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	device, jsonErr := a.getOwnedDevice(c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	wipe, jsonErr := wipeOnRelease(a.Cfg, c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	owner := *device.Owner
+	message := fmt.Sprintf("device %d has been released by an administrator: %s", device.ID, revocation.Reason)
+
+	// the device stays leased until it is wiped, so that nobody can
+	// acquire it in the meantime
+	if wipe {
+		job, jsonErr := services.SubmitWipe(a.Jobs, a.Providers, a.Provisioner, device, models.EnumLeaseRevoked, revocation.Reason, ExtractIDFromToken(c))
+		if jsonErr != nil {
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+
+		services.Notify(a.DB, owner, models.EnumNotificationDeviceRevoked, device.ID, message)
+		return c.JSON(http.StatusAccepted, job)
+	}
+
+	if jsonErr := models.RevokeDevice(a.DB, device, revocation.Reason); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	services.Notify(a.DB, owner, models.EnumNotificationDeviceRevoked, device.ID, message)
+	a.Queue.Process()
+
+	return c.JSON(http.StatusOK, device)
+}
+
+// TransferDevice -
+// @description Give the `Device` with the given id to another `User`. The lease of the current owner ends as `transferred` with the given reason, and the new owner gets a lease expiring at the same time, or after the given `duration`. Unless `force` is set, the transfer is refused if it would exceed the quota of the new owner (403) or overlap a reservation of another user (409). Both users are notified.
+// @id transferDevice
+// @tags admin
+// @summary transfer a device to another user
+// @accept json
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `Device` to transfer"
+// @param RequestBody body models.DeviceTransfer true "The id of the new owner, why the device is transferred, and whether to skip the quota and reservation checks"
+// @param duration query string false "How long the new owner leases the device (e.g. `4h`), defaults to the remaining time of the current lease"
+// @success 200 {object} models.Device
+// @router /admin/device/{id}/transfer [post]
+func (a *AdminController) TransferDevice(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	transfer := models.DeviceTransfer{}
+	if err := c.Bind(&transfer); err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if transfer.Reason == "" {
+		jsonErr := models.JSONError{
+			Status: http.StatusBadRequest,
+			Error:  "reason is required.",
+		}
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	user, jsonErr := models.GetUser(a.DB, transfer.User)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	device, jsonErr := a.getOwnedDevice(c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	owner := *device.Owner
+	if owner == transfer.User {
+		jsonErr := models.JSONError{
+			Status: http.StatusConflict,
+			Error:  "device is already owned by this user.",
+		}
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	// the new owner takes over the remaining time of the lease by default
+	var duration time.Duration
+	if device.LeaseExpiresAt != nil {
+		duration = time.Until(*device.LeaseExpiresAt)
+	}
+	if c.QueryParam("duration") != "" || duration <= 0 {
+		duration, jsonErr = leaseDuration(a.Cfg, c)
+		if jsonErr != nil {
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+	}
+
+	var quota *models.Quota
+	if !transfer.Force {
+		roleQuota := services.ReadQuota(a.Cfg, user.Role)
+		quota = &roleQuota
+
+		now := time.Now()
+		if jsonErr := models.CheckReservations(a.DB, []int64{device.ID}, now, now.Add(duration), transfer.User); jsonErr != nil {
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+	}
+
+	if jsonErr := models.TransferDevice(a.DB, device, transfer.User, duration, quota, transfer.Reason); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	services.Notify(a.DB, owner, models.EnumNotificationDeviceRevoked, device.ID,
+		fmt.Sprintf("device %d has been transferred to another user by an administrator: %s", device.ID, transfer.Reason))
+	services.Notify(a.DB, transfer.User, models.EnumNotificationDeviceGranted, device.ID,
+		fmt.Sprintf("device %d has been transferred to you by an administrator until %s: %s",
+			device.ID, device.LeaseExpiresAt.Format(time.RFC3339), transfer.Reason))
+
+	return c.JSON(http.StatusOK, device)
+}
+
+// checkHostname makes sure the given `hostname` can name the directories of
+// a new `Device`, which must not hold any `Image`
+func (a *AdminController) checkHostname(hostname string) *models.JSONError {
+	images, jsonErr := models.GetAllImages(a.DB, true)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	paths := []string{}
+	for _, image := range *images {
+		paths = append(paths, image.Path)
+	}
+
+	if err := a.Provisioner.CheckHostname(hostname, paths...); err != nil {
+		return &models.JSONError{
+			Status: http.StatusBadRequest,
+			Error:  fmt.Sprintf("%s: %s.", hostname, err),
+		}
+	}
+
+	return nil
+}
+
+// getOwnedDevice returns the `Device` whose id is given in the path, if it is
+// owned by a `User`
+func (a *AdminController) getOwnedDevice(c echo.Context) (*models.Device, *models.JSONError) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, models.NewBadRequestError()
+	}
+
+	device, jsonErr := models.GetDevice(a.DB, int64(id))
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	if device.Owner == nil {
+		return nil, &models.JSONError{
+			Status: http.StatusConflict,
+			Error:  "device is not owned.",
+		}
+	}
+
+	return device, nil
+}
@@ -1,18 +1,24 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-pg/pg/v9"
 	"github.com/labstack/echo/v4"
 	"github.com/xiorcale/rubus-api/models"
 	"github.com/xiorcale/rubus-api/services"
+	"gopkg.in/ini.v1"
 )
 
 // DeviceController -
 type DeviceController struct {
-	DB *pg.DB
+	DB        *pg.DB
+	Cfg       *ini.File
+	Providers *services.ProviderRegistry
+	Jobs      *services.JobRunner
 }
 
 // ListDevice -
@@ -70,8 +76,7 @@ func (d *DeviceController) Get(c echo.Context) error {
 // @success 204
 // @router /device/{id}/on [post]
 func (d *DeviceController) PowerOn(c echo.Context) error {
-	port := c.Param("id")
-	id, _ := strconv.Atoi(port)
+	id, _ := strconv.Atoi(c.Param("id"))
 	device, jsonErr := models.GetDevice(d.DB, int64(id))
 	if jsonErr != nil {
 		return echo.NewHTTPError(jsonErr.Status, jsonErr)
@@ -83,19 +88,15 @@ func (d *DeviceController) PowerOn(c echo.Context) error {
 		}
 	}
 
-	if jsonErr := services.PowerDeviceOn(port); jsonErr != nil {
+	if jsonErr := services.PowerOn(d.DB, d.Providers, device); jsonErr != nil {
 		return echo.NewHTTPError(jsonErr.Status, jsonErr)
 	}
 
-	if !device.IsTurnedOn {
-		models.SwitchDevicePower(d.DB, device)
-	}
-
 	return c.NoContent(http.StatusNoContent)
 }
 
 // PowerOff -
-// @description Shuts down the `Device` on the given `port`
+// @description Shuts down the `Device` with the given `id`.
 // @id powerOff
 // @tags device
 // @summary Shut down a device
@@ -105,8 +106,7 @@ func (d *DeviceController) PowerOn(c echo.Context) error {
 // @success 204
 // @router /device/{id}/off [post]
 func (d *DeviceController) PowerOff(c echo.Context) error {
-	port := c.Param("id")
-	id, _ := strconv.Atoi(port)
+	id, _ := strconv.Atoi(c.Param("id"))
 	device, jsonErr := models.GetDevice(d.DB, int64(id))
 	if jsonErr != nil {
 		return echo.NewHTTPError(jsonErr.Status, jsonErr)
@@ -118,13 +118,173 @@ func (d *DeviceController) PowerOff(c echo.Context) error {
 		}
 	}
 
-	if jsonErr := services.PowerDeviceOff(port); jsonErr != nil {
+	if jsonErr := services.PowerOff(d.DB, d.Providers, device); jsonErr != nil {
 		return echo.NewHTTPError(jsonErr.Status, jsonErr)
 	}
 
-	if device.IsTurnedOn {
-		models.SwitchDevicePower(d.DB, device)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Reboot -
+// @description Power cycle the `Device` with the given `id`: shut it down, wait for the off-delay so that the PoE port stays down long enough, and boot it again. The power cycle runs in the background, its progress can be followed through the returned `Job`. It is refused while another job runs on the device.
+// @id reboot
+// @tags device
+// @summary Reboot a device
+// @produce json
+// @security jwt
+// @param id path int true "The device id to reboot"
+// @param delay query string false "How long the device stays off (e.g. `10s`), defaults to and is at least the configured `cycle_delay`, at most `max_cycle_delay`"
+// @success 202 {object} models.Job
+// @router /device/{id}/reboot [post]
+func (d *DeviceController) Reboot(c echo.Context) error {
+	id, _ := strconv.Atoi(c.Param("id"))
+	device, jsonErr := models.GetDevice(d.DB, int64(id))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
 	}
 
-	return c.NoContent(http.StatusNoContent)
+	if device.Owner != nil {
+		if jsonErr := FilterIDOrAdmin(c, *device.Owner); jsonErr != nil {
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+	}
+
+	section := d.Cfg.Section("provider")
+	delay := section.Key("cycle_delay").MustDuration(5 * time.Second)
+	maxDelay := section.Key("max_cycle_delay").MustDuration(5 * time.Minute)
+	if param := c.QueryParam("delay"); param != "" {
+		parsed, err := time.ParseDuration(param)
+		if err != nil || parsed < 0 {
+			jsonErr := models.JSONError{
+				Status: http.StatusBadRequest,
+				Error:  "delay is not valid.",
+			}
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+
+		// the PoE port must stay down long enough for the device to turn off
+		switch {
+		case parsed < delay:
+		case parsed > maxDelay:
+			delay = maxDelay
+		default:
+			delay = parsed
+		}
+	}
+
+	job := models.Job{
+		Type:        models.EnumJobReboot,
+		DeviceID:    &device.ID,
+		RequesterID: ExtractIDFromToken(c),
+	}
+
+	if jsonErr := d.Jobs.Submit(&job, services.RebootTask(d.DB, d.Providers, device, delay)); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// Logs -
+// @description Return the jobs performed on the `Device` with the given `id` (most recent first), along with the output and exit code of the scripts they ran. With `follow=true`, the output of the most recent job is streamed as plain text until the job ends.
+// @id deviceLogs
+// @tags device
+// @summary get the logs of a device
+// @produce json
+// @produce plain
+// @security jwt
+// @param id path int true "The id of the `Device`"
+// @param follow query bool false "Stream the output of the most recent job while it runs"
+// @success 200 {array} models.Job
+// @router /device/{id}/logs [get]
+func (d *DeviceController) Logs(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	device, jsonErr := models.GetDevice(d.DB, int64(id))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if device.Owner != nil {
+		if jsonErr := FilterIDOrAdmin(c, *device.Owner); jsonErr != nil {
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+	}
+
+	jobs, jsonErr := models.GetJobsByDevice(d.DB, device.ID)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if follow, _ := strconv.ParseBool(c.QueryParam("follow")); follow {
+		if len(*jobs) == 0 {
+			jsonErr := models.JSONError{
+				Status: http.StatusNotFound,
+				Error:  "device has no logs.",
+			}
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+		return d.streamOutput(c, (*jobs)[0].ID)
+	}
+
+	// the output of the running jobs is only saved once they end
+	for i := range *jobs {
+		if output, running := d.Jobs.Output((*jobs)[i].ID); running {
+			(*jobs)[i].Output = output
+		}
+	}
+
+	return c.JSON(http.StatusOK, jobs)
+}
+
+// streamOutput writes the output of the `Job` with the given `jobID` as it
+// is produced, until the job ends or the client goes away
+func (d *DeviceController) streamOutput(c echo.Context, jobID int64) error {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, echo.MIMETextPlainCharsetUTF8)
+	res.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	sent := 0
+	for {
+		output, running := d.Jobs.Output(jobID)
+
+		var job *models.Job
+		if !running {
+			// the job has ended, its whole output is saved in the database
+			var jsonErr *models.JSONError
+			if job, jsonErr = models.GetJob(d.DB, jobID); jsonErr != nil {
+				return nil
+			}
+			output = job.Output
+		}
+
+		if len(output) > sent {
+			res.Write([]byte(output[sent:]))
+			sent = len(output)
+		}
+
+		if job != nil {
+			fmt.Fprintf(res, "\n--- job %d %s", job.ID, job.Status)
+			if job.ExitCode != nil {
+				fmt.Fprintf(res, " (exit code %d)", *job.ExitCode)
+			}
+			fmt.Fprintln(res)
+			res.Flush()
+			return nil
+		}
+		res.Flush()
+
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
 }
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/labstack/echo/v4"
+	"github.com/xiorcale/rubus-api/models"
+	"github.com/xiorcale/rubus-api/provisioning"
+)
+
+// ImageController -
+type ImageController struct {
+	DB          *pg.DB
+	Provisioner *provisioning.Provisioner
+}
+
+// ListImage -
+// @description List the `Image` which can be deployed on the devices. Retired images are not listed.
+// @id listImage
+// @tags image
+// @summary list the deployable images
+// @produce json
+// @security jwt
+// @success 200 {array} models.Image "A JSON array listing the images"
+// @router /image [get]
+func (i *ImageController) ListImage(c echo.Context) error {
+	images, jsonErr := models.GetAllImages(i.DB, false)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, images)
+}
+
+// ListAllImage -
+// @description List every `Image` of the catalog, including the retired ones.
+// @id listAllImage
+// @tags admin
+// @summary List all the images
+// @produce json
+// @security jwt
+// @success 200 {array} models.Image "A JSON array listing the images"
+// @router /admin/image [get]
+func (i *ImageController) ListAllImage(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	images, jsonErr := models.GetAllImages(i.DB, true)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, images)
+}
+
+// GetImage -
+// @description Return the `Image` with the given `id`.
+// @id getImage
+// @tags admin
+// @summary Describe an image
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `Image` to get"
+// @success 200 {object} models.Image
+// @router /admin/image/{id} [get]
+func (i *ImageController) GetImage(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	image, jsonErr := models.GetImage(i.DB, int64(id))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, image)
+}
+
+// CreateImage -
+// @description Register a new `Image` in the catalog. The `path` is the directory, on the Rubus server, holding the root file system used as the lower layer of the devices' overlay.
+// @id createImage
+// @tags admin
+// @summary Register a new image
+// @accept json
+// @produce json
+// @security jwt
+// @param RequestBody body models.NewImage true "The `name`, `version` and `path` are required."
+// @success 201 {object} models.Image
+// @router /admin/image [post]
+func (i *ImageController) CreateImage(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	newImage := models.NewImage{}
+	if err := c.Bind(&newImage); err != nil || newImage.Name == "" || newImage.Version == "" || newImage.Path == "" {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if err := i.Provisioner.CheckImage(newImage.Path); err != nil {
+		jsonErr := models.JSONError{
+			Status: http.StatusBadRequest,
+			Error:  err.Error(),
+		}
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	// the image must not lie within the directories of a device
+	devices, jsonErr := models.GetAllDevices(i.DB)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+	for _, device := range *devices {
+		if device.Hostname != "" && i.Provisioner.UsesPath(device.Hostname, newImage.Path) {
+			jsonErr := models.JSONError{
+				Status: http.StatusConflict,
+				Error:  fmt.Sprintf("image path is used by device %d.", device.ID),
+			}
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+	}
+
+	image := models.Image{
+		Name:         newImage.Name,
+		Version:      newImage.Version,
+		Architecture: newImage.Architecture,
+		Checksum:     newImage.Checksum,
+		Description:  newImage.Description,
+		Path:         newImage.Path,
+	}
+
+	if jsonErr := models.AddImage(i.DB, &image); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusCreated, image)
+}
+
+// RetireImage -
+// @description Retire the `Image` with the given `id`: it cannot be chosen for new deployments anymore, but the devices already running it are not affected.
+// @id retireImage
+// @tags admin
+// @summary Retire an image
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `Image` to retire"
+// @success 200 {object} models.Image
+// @router /admin/image/{id} [delete]
+func (i *ImageController) RetireImage(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	image, jsonErr := models.RetireImage(i.DB, int64(id))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, image)
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/labstack/echo/v4"
+	"github.com/xiorcale/rubus-api/models"
+	"github.com/xiorcale/rubus-api/services"
+)
+
+// JobController -
+type JobController struct {
+	DB   *pg.DB
+	Jobs *services.JobRunner
+}
+
+// ListJob -
+// @description List the `Job` requested by the `User` who made the request, most recent first. Administrators see every job.
+// @id listJob
+// @tags job
+// @summary list the jobs
+// @produce json
+// @security jwt
+// @success 200 {array} models.Job "A JSON array listing the jobs"
+// @router /job [get]
+func (j *JobController) ListJob(c echo.Context) error {
+	var requesterID *int64
+	if FilterAdmin(c) != nil {
+		id := ExtractIDFromToken(c)
+		requesterID = &id
+	}
+
+	jobs, jsonErr := models.GetAllJobs(j.DB, requesterID)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, jobs)
+}
+
+// Get -
+// @description Return the `Job` with the given `id`.
+// @id getJob
+// @tags job
+// @summary get a job by id
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `Job` to get"
+// @success 200 {object} models.Job
+// @router /job/{id} [get]
+func (j *JobController) Get(c echo.Context) error {
+	job, jsonErr := j.getJob(c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
+
+// Cancel -
+// @description Cancel the `Job` with the given `id`, if it is still running.
+// @id cancelJob
+// @tags job
+// @summary cancel a job
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `Job` to cancel"
+// @success 202 {object} models.Job
+// @router /job/{id}/cancel [post]
+func (j *JobController) Cancel(c echo.Context) error {
+	job, jsonErr := j.getJob(c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := j.Jobs.Cancel(job.ID); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// getJob returns the `Job` whose id is given in the path, as long as the
+// `User` who made the request is its requester or an administrator
+func (j *JobController) getJob(c echo.Context) (*models.Job, *models.JSONError) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, models.NewBadRequestError()
+	}
+
+	job, jsonErr := models.GetJob(j.DB, int64(id))
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	if jsonErr := FilterIDOrAdmin(c, job.RequesterID); jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	return job, nil
+}
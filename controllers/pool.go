@@ -0,0 +1,635 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/labstack/echo/v4"
+	"github.com/xiorcale/rubus-api/models"
+	"github.com/xiorcale/rubus-api/provisioning"
+	"github.com/xiorcale/rubus-api/services"
+	"gopkg.in/ini.v1"
+)
+
+// PoolController -
+type PoolController struct {
+	DB          *pg.DB
+	Cfg         *ini.File
+	Providers   *services.ProviderRegistry
+	Jobs        *services.JobRunner
+	Provisioner *provisioning.Provisioner
+	Queue       *services.WaitQueue
+}
+
+// CreatePool -
+// @description Create a new `DevicePool`, to which devices can then be added.
+// @id createPool
+// @tags admin
+// @summary create a device pool
+// @accept json
+// @produce json
+// @security jwt
+// @param RequestBody body models.NewDevicePool true "The name and description of the pool"
+// @success 201 {object} models.DevicePool
+// @router /admin/pool [post]
+func (p *PoolController) CreatePool(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	newPool := models.NewDevicePool{}
+	if err := c.Bind(&newPool); err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if newPool.Name == "" {
+		jsonErr := models.JSONError{
+			Status: http.StatusBadRequest,
+			Error:  "name is required.",
+		}
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	pool := models.DevicePool{
+		Name:        newPool.Name,
+		Description: newPool.Description,
+	}
+
+	if jsonErr := models.AddDevicePool(p.DB, &pool); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusCreated, pool)
+}
+
+// DeletePool -
+// @description Delete the `DevicePool` with the given `name`. Its devices are kept, but do not belong to any pool anymore.
+// @id deletePool
+// @tags admin
+// @summary delete a device pool
+// @produce json
+// @security jwt
+// @param name path string true "The name of the `DevicePool` to delete"
+// @success 204
+// @router /admin/pool/{name} [delete]
+func (p *PoolController) DeletePool(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := models.DeleteDevicePool(p.DB, c.Param("name")); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// AddPoolDevice -
+// @description Move the given devices into the `DevicePool` with the given `name`. A device belongs to at most one pool, so the devices leave their previous pool. Either every device is moved, or none.
+// @id addPoolDevice
+// @tags admin
+// @summary add devices to a pool
+// @accept json
+// @produce json
+// @security jwt
+// @param name path string true "The name of the `DevicePool`"
+// @param RequestBody body models.PoolMembers true "The ids of the devices to add"
+// @success 200 {array} models.Device "A JSON array listing the devices of the pool"
+// @router /admin/pool/{name}/device [post]
+func (p *PoolController) AddPoolDevice(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	members := models.PoolMembers{}
+	if err := c.Bind(&members); err != nil || len(members.Devices) == 0 {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	pool, jsonErr := models.GetDevicePool(p.DB, c.Param("name"))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	deviceIDs := []int64{}
+	seen := map[int64]bool{}
+	for _, deviceID := range members.Devices {
+		if !seen[deviceID] {
+			seen[deviceID] = true
+			deviceIDs = append(deviceIDs, deviceID)
+		}
+	}
+
+	if jsonErr := models.AddPoolDevices(p.DB, pool.Name, deviceIDs); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	devices, jsonErr := models.GetPoolDevices(p.DB, pool.Name)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, devices)
+}
+
+// RemovePoolDevice -
+// @description Remove the `Device` with the given `id` from the `DevicePool` with the given `name`.
+// @id removePoolDevice
+// @tags admin
+// @summary remove a device from a pool
+// @produce json
+// @security jwt
+// @param name path string true "The name of the `DevicePool`"
+// @param id path int true "The id of the `Device` to remove"
+// @success 204
+// @router /admin/pool/{name}/device/{id} [delete]
+func (p *PoolController) RemovePoolDevice(c echo.Context) error {
+	if jsonErr := FilterAdmin(c); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := models.RemovePoolDevice(p.DB, c.Param("name"), int64(id)); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListPool -
+// @description List all the `DevicePool`, along with their number of devices.
+// @id listPool
+// @tags pool
+// @summary list the device pools
+// @produce json
+// @security jwt
+// @success 200 {array} models.DevicePool "A JSON array listing the pools"
+// @router /pool [get]
+func (p *PoolController) ListPool(c echo.Context) error {
+	pools, jsonErr := models.GetAllDevicePools(p.DB)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, pools)
+}
+
+// Get -
+// @description Return the `DevicePool` with the given `name`, along with its number of devices.
+// @id getPool
+// @tags pool
+// @summary get a device pool by name
+// @produce json
+// @security jwt
+// @param name path string true "The name of the `DevicePool` to get"
+// @success 200 {object} models.DevicePool
+// @router /pool/{name} [get]
+func (p *PoolController) Get(c echo.Context) error {
+	pool, jsonErr := models.GetDevicePool(p.DB, c.Param("name"))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, pool)
+}
+
+// ListPoolDevice -
+// @description List the devices of the `DevicePool` with the given `name`.
+// @id listPoolDevice
+// @tags pool
+// @summary list the devices of a pool
+// @produce json
+// @security jwt
+// @param name path string true "The name of the `DevicePool`"
+// @success 200 {array} models.Device "A JSON array listing the devices of the pool"
+// @router /pool/{name}/device [get]
+func (p *PoolController) ListPoolDevice(c echo.Context) error {
+	pool, jsonErr := models.GetDevicePool(p.DB, c.Param("name"))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	devices, jsonErr := models.GetPoolDevices(p.DB, pool.Name)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, devices)
+}
+
+// PowerOn -
+// @description Turn on every device of the `DevicePool` with the given `name`, as one `Batch`. Each device is turned on by its own `Job` running in the background, and the batch lists the job of each device along with its status. The devices owned by another user are left untouched, unless the request is made by an administrator.
+// @id powerOnPool
+// @tags pool
+// @summary turn on the devices of a pool
+// @produce json
+// @security jwt
+// @param name path string true "The name of the `DevicePool`"
+// @success 202 {object} models.Batch
+// @router /pool/{name}/on [post]
+func (p *PoolController) PowerOn(c echo.Context) error {
+	batch, jsonErr := p.runBatch(c, models.EnumBatchPowerOn, func(device *models.Device, batch *models.Batch) models.BatchResult {
+		if jsonErr := p.filterOwner(c, device); jsonErr != nil {
+			return failedResult(device, jsonErr)
+		}
+		return p.submitJob(device, batch, models.EnumJobPowerOn, services.PowerOnTask(p.DB, p.Providers, device))
+	})
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusAccepted, batch)
+}
+
+// PowerOff -
+// @description Turn off every device of the `DevicePool` with the given `name`, as one `Batch`. Each device is turned off by its own `Job` running in the background, and the batch lists the job of each device along with its status. The devices owned by another user are left untouched, unless the request is made by an administrator.
+// @id powerOffPool
+// @tags pool
+// @summary turn off the devices of a pool
+// @produce json
+// @security jwt
+// @param name path string true "The name of the `DevicePool`"
+// @success 202 {object} models.Batch
+// @router /pool/{name}/off [post]
+func (p *PoolController) PowerOff(c echo.Context) error {
+	batch, jsonErr := p.runBatch(c, models.EnumBatchPowerOff, func(device *models.Device, batch *models.Batch) models.BatchResult {
+		if jsonErr := p.filterOwner(c, device); jsonErr != nil {
+			return failedResult(device, jsonErr)
+		}
+		return p.submitJob(device, batch, models.EnumJobPowerOff, services.PowerOffTask(p.DB, p.Providers, device))
+	})
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusAccepted, batch)
+}
+
+// Deploy -
+// @description Deploy every device of the `DevicePool` with the given `name`, as one `Batch`. Each device is deployed by its own `Job` running in the background, and the batch lists the job of each device along with its status. Without `image`, each device is deployed with the image currently deployed on it. The devices owned by another user are left untouched, unless the request is made by an administrator.
+// @id deployPool
+// @tags pool
+// @summary deploy the devices of a pool
+// @accept json
+// @produce json
+// @security jwt
+// @param name path string true "The name of the `DevicePool`"
+// @param RequestBody body models.DeployDevice false "The id of the `Image` to deploy, and the first boot configuration"
+// @success 202 {object} models.Batch
+// @router /pool/{name}/deploy [post]
+func (p *PoolController) Deploy(c echo.Context) error {
+	deploy := models.DeployDevice{}
+	if err := c.Bind(&deploy); err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if deploy.Image != nil {
+		image, jsonErr := models.GetImage(p.DB, *deploy.Image)
+		if jsonErr != nil {
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+		if image.Retired {
+			jsonErr := models.JSONError{
+				Status: http.StatusConflict,
+				Error:  "image is retired.",
+			}
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+	}
+
+	defaultUser := p.Cfg.Section("provisioning").Key("default_user").MustString("pi")
+
+	batch, jsonErr := p.runBatch(c, models.EnumBatchDeploy, func(device *models.Device, batch *models.Batch) models.BatchResult {
+		if jsonErr := p.filterOwner(c, device); jsonErr != nil {
+			return failedResult(device, jsonErr)
+		}
+
+		image, jsonErr := deployImage(p.DB, device, deploy.Image)
+		if jsonErr != nil {
+			return failedResult(device, jsonErr)
+		}
+
+		firstBoot, jsonErr := services.DeviceFirstBoot(p.DB, defaultUser, device, deploy.UserData)
+		if jsonErr != nil {
+			return failedResult(device, jsonErr)
+		}
+		if firstBoot != nil {
+			if err := firstBoot.Validate(); err != nil {
+				return failedResult(device, &models.JSONError{Status: http.StatusBadRequest, Error: err.Error()})
+			}
+		}
+
+		task := services.DeployTask(p.DB, p.Providers, p.Provisioner, device, image, firstBoot)
+		return p.submitJob(device, batch, models.EnumJobDeploy, task)
+	})
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusAccepted, batch)
+}
+
+// Acquire -
+// @description Set the `User` who made the request as the owner of every device of the `DevicePool` with the given `name`, for a lease of the given `duration`, as one `Batch` listing the result on each device. Either every device which is not owned by the user yet is acquired, or none: if any device is owned by another user, missing, or booked by another user during the lease, the batch fails and lists why. The request is refused (403), without any batch, if it would exceed the quota of the user.
+// @id acquirePool
+// @tags pool
+// @summary acquire the devices of a pool
+// @produce json
+// @security jwt
+// @param name path string true "The name of the `DevicePool`"
+// @param duration query string false "How long the devices are leased (e.g. `4h`), defaults to the configured `default_duration`"
+// @success 200 {object} models.Batch
+// @router /pool/{name}/acquire [post]
+func (p *PoolController) Acquire(c echo.Context) error {
+	userID := ExtractIDFromToken(c)
+
+	pool, jsonErr := models.GetDevicePool(p.DB, c.Param("name"))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	devices, jsonErr := models.GetPoolDevices(p.DB, pool.Name)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	duration, jsonErr := leaseDuration(p.Cfg, c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	// every device must be available before any of them is acquired
+	now := time.Now()
+	results := []models.BatchResult{}
+	candidates := []int{}
+	available := true
+	for i := range *devices {
+		device := &(*devices)[i]
+
+		var jsonErr *models.JSONError
+		switch {
+		case device.Owner != nil && *device.Owner == userID:
+			results = append(results, batchResult(device, nil))
+			continue
+		case device.Owner != nil:
+			jsonErr = &models.JSONError{Status: http.StatusConflict, Error: "device is owned by another user."}
+		case device.IsMissing:
+			jsonErr = &models.JSONError{Status: http.StatusConflict, Error: "device is missing."}
+		default:
+			jsonErr = models.CheckReservations(p.DB, []int64{device.ID}, now, now.Add(duration), userID)
+		}
+
+		if jsonErr != nil {
+			available = false
+			results = append(results, failedResult(device, jsonErr))
+			continue
+		}
+
+		candidates = append(candidates, len(results))
+		results = append(results, models.BatchResult{DeviceID: device.ID})
+	}
+
+	quota, jsonErr := services.RoleQuota(p.DB, p.Cfg, userID)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	var acquireErr *models.JSONError
+	if !available {
+		acquireErr = &models.JSONError{Error: "not acquired, since the pool is not entirely available."}
+	} else if len(candidates) > 0 {
+		criteria := models.DeviceCriteria{Count: len(candidates), Pool: pool.Name, IDs: []int64{}}
+		for _, i := range candidates {
+			criteria.IDs = append(criteria.IDs, results[i].DeviceID)
+		}
+		_, acquireErr = models.AcquireDevices(p.DB, &criteria, userID, duration, quota)
+	}
+
+	if acquireErr != nil && acquireErr.Status == http.StatusForbidden {
+		return echo.NewHTTPError(acquireErr.Status, acquireErr)
+	}
+
+	for _, i := range candidates {
+		results[i].Status = models.EnumJobSucceeded
+		if acquireErr != nil {
+			results[i].Status = models.EnumJobCanceled
+			results[i].Error = acquireErr.Error
+		}
+	}
+
+	batch := models.Batch{
+		Operation:   models.EnumBatchAcquire,
+		Pool:        pool.Name,
+		RequesterID: userID,
+		Results:     results,
+	}
+
+	if jsonErr := models.AddBatch(p.DB, &batch); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, batch)
+}
+
+// Release -
+// @description Remove the ownership of every device of the `DevicePool` with the given `name` from the `User` who made the request, as one `Batch` listing the result on each device. The devices owned by another user are left untouched, unless the request is made by an administrator. Depending on the `wipe_on_release` policy, the devices can also be shut down and their overlay reset to its pristine state, in which case each device is wiped by its own `Job` running in the background (202), and stays leased until its wipe succeeds. Once free, the devices are granted to the users waiting for them in the queue.
+// @id releasePool
+// @tags pool
+// @summary release the devices of a pool
+// @produce json
+// @security jwt
+// @param name path string true "The name of the `DevicePool`"
+// @param wipe query bool false "Wipe the devices (only if the policy is `optional`)"
+// @success 200 {object} models.Batch
+// @success 202 {object} models.Batch
+// @router /pool/{name}/release [post]
+func (p *PoolController) Release(c echo.Context) error {
+	wipe, jsonErr := wipeOnRelease(p.Cfg, c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	batch, jsonErr := p.runBatch(c, models.EnumBatchRelease, func(device *models.Device, batch *models.Batch) models.BatchResult {
+		if device.Owner == nil {
+			return batchResult(device, nil)
+		}
+		if jsonErr := p.filterOwner(c, device); jsonErr != nil {
+			return failedResult(device, jsonErr)
+		}
+
+		// the device stays leased until it is wiped, so that nobody can
+		// acquire it in the meantime
+		if wipe {
+			task, jsonErr := services.DeviceReleaseWipeTask(p.DB, p.Providers, p.Provisioner, device, models.EnumLeaseReleased, "")
+			if jsonErr != nil {
+				return failedResult(device, jsonErr)
+			}
+			return p.submitJob(device, batch, models.EnumJobWipe, task)
+		}
+
+		return batchResult(device, models.ReleaseDevice(p.DB, device, models.EnumLeaseReleased))
+	})
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if wipe {
+		return c.JSON(http.StatusAccepted, batch)
+	}
+
+	p.Queue.Process()
+
+	return c.JSON(http.StatusOK, batch)
+}
+
+// ListBatch -
+// @description List the `Batch` requested by the `User` who made the request, most recent first. Administrators see every batch.
+// @id listBatch
+// @tags pool
+// @summary list the batches
+// @produce json
+// @security jwt
+// @param pool query string false "Only list the batches performed on this `DevicePool`"
+// @success 200 {array} models.Batch "A JSON array listing the batches"
+// @router /batch [get]
+func (p *PoolController) ListBatch(c echo.Context) error {
+	var requesterID *int64
+	if FilterAdmin(c) != nil {
+		id := ExtractIDFromToken(c)
+		requesterID = &id
+	}
+
+	batches, jsonErr := models.GetAllBatches(p.DB, requesterID, c.QueryParam("pool"))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, batches)
+}
+
+// GetBatch -
+// @description Return the `Batch` with the given `id`, along with its result on each device. The results of a deployment follow the status of the jobs deploying the devices.
+// @id getBatch
+// @tags pool
+// @summary get a batch by id
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `Batch` to get"
+// @success 200 {object} models.Batch
+// @router /batch/{id} [get]
+func (p *PoolController) GetBatch(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	batch, jsonErr := models.GetBatch(p.DB, int64(id))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := FilterIDOrAdmin(c, batch.RequesterID); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, batch)
+}
+
+// runBatch performs the `operation` on every device of the `DevicePool` whose
+// name is given in the path, through `do` which returns the result on one
+// device, and saves it as one `Batch`
+func (p *PoolController) runBatch(c echo.Context, operation models.BatchOperation, do func(*models.Device, *models.Batch) models.BatchResult) (*models.Batch, *models.JSONError) {
+	pool, jsonErr := models.GetDevicePool(p.DB, c.Param("name"))
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	devices, jsonErr := models.GetPoolDevices(p.DB, pool.Name)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	// the batch is saved first, so that its jobs can refer to it
+	batch := &models.Batch{
+		Operation:   operation,
+		Pool:        pool.Name,
+		RequesterID: ExtractIDFromToken(c),
+		Results:     []models.BatchResult{},
+	}
+	if jsonErr := models.AddBatch(p.DB, batch); jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	for i := range *devices {
+		batch.Results = append(batch.Results, do(&(*devices)[i], batch))
+	}
+
+	if jsonErr := models.UpdateBatchResults(p.DB, batch); jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	return batch, nil
+}
+
+// submitJob submits a `Job` of the given `jobType` performing the `task` on
+// the `Device` as part of the `Batch`, and returns the result on the device,
+// following the status of the job
+func (p *PoolController) submitJob(device *models.Device, batch *models.Batch, jobType models.JobType, task services.JobTask) models.BatchResult {
+	job := models.Job{
+		Type:        jobType,
+		DeviceID:    &device.ID,
+		BatchID:     &batch.ID,
+		RequesterID: batch.RequesterID,
+	}
+
+	if jsonErr := p.Jobs.Submit(&job, task); jsonErr != nil {
+		return failedResult(device, jsonErr)
+	}
+
+	return models.BatchResult{DeviceID: device.ID, Status: job.Status, JobID: &job.ID}
+}
+
+// filterOwner makes sure the `Device` is free, or owned by the `User` who
+// made the request, unless they are an administrator
+func (p *PoolController) filterOwner(c echo.Context, device *models.Device) *models.JSONError {
+	if device.Owner == nil {
+		return nil
+	}
+
+	if FilterIDOrAdmin(c, *device.Owner) != nil {
+		return &models.JSONError{
+			Status: http.StatusForbidden,
+			Error:  fmt.Sprintf("device %d is owned by another user.", device.ID),
+		}
+	}
+
+	return nil
+}
+
+// batchResult returns the result of an operation performed on the `Device`,
+// which failed if `jsonErr` is not nil
+func batchResult(device *models.Device, jsonErr *models.JSONError) models.BatchResult {
+	if jsonErr != nil {
+		return failedResult(device, jsonErr)
+	}
+
+	return models.BatchResult{DeviceID: device.ID, Status: models.EnumJobSucceeded}
+}
+
+// failedResult returns the result of an operation which failed on the
+// `Device` with the given `jsonErr`
+func failedResult(device *models.Device, jsonErr *models.JSONError) models.BatchResult {
+	return models.BatchResult{DeviceID: device.ID, Status: models.EnumJobFailed, Error: jsonErr.Error}
+}
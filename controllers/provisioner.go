@@ -1,101 +1,290 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
-	"os/exec"
 	"strconv"
+	"time"
 
 	"github.com/go-pg/pg/v9"
 	"github.com/labstack/echo/v4"
 	"github.com/xiorcale/rubus-api/models"
+	"github.com/xiorcale/rubus-api/provisioning"
 	"github.com/xiorcale/rubus-api/services"
+	"gopkg.in/ini.v1"
 )
 
 // ProvisionerController -
 type ProvisionerController struct {
-	DB *pg.DB
+	DB          *pg.DB
+	Cfg         *ini.File
+	Providers   *services.ProviderRegistry
+	Jobs        *services.JobRunner
+	Provisioner *provisioning.Provisioner
+	Queue       *services.WaitQueue
 }
 
 // Acquire -
-// @description Set the `User` who made the request as the owner of the `Device`.
+// @description Set the `User` who made the request as the owner of the `Device`, for a lease of the given `duration`. Once the lease expires, the device is automatically released unless the lease has been renewed. The device cannot be acquired if another user owns it, booked it during the lease, is waiting for it in the queue, or acquired it in the meantime (409), nor if it would exceed the quota of the user (403). The owner of the device cannot acquire it again either (409), but renews its lease instead. To wait for a device owned by another user, join the queue instead.
 // @id acquire
 // @tags device
 // @summary acquire a device
 // @produce json
 // @security jwt
 // @param id path int true "The id of the `Device` to acquire"
+// @param duration query string false "How long the device is leased (e.g. `4h`), defaults to the configured `default_duration`"
 // @success 200 {object} models.Device
 // @router /device/{id}/acquire [post]
 func (p *ProvisionerController) Acquire(c echo.Context) error {
-	port, _ := strconv.Atoi(c.Param("id"))
+	id, _ := strconv.Atoi(c.Param("id"))
 	userID := ExtractIDFromToken(c)
 
 	// get the requested `Device`
-	device, jsonErr := models.GetDevice(p.DB, int64(port))
+	device, jsonErr := models.GetDevice(p.DB, int64(id))
 	if jsonErr != nil {
 		return echo.NewHTTPError(jsonErr.Status, jsonErr)
 	}
 
+	// the owner renews its lease instead, and administrators take devices
+	// over through a transfer
+	if device.Owner != nil && *device.Owner == userID {
+		jsonErr := models.JSONError{
+			Status: http.StatusConflict,
+			Error:  "device is already owned by this user, renew its lease instead.",
+		}
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
 	if device.Owner != nil {
-		if jsonErr := FilterIDOrAdmin(c, *device.Owner); jsonErr != nil {
-			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		jsonErr := models.JSONError{
+			Status: http.StatusConflict,
+			Error:  "device is owned by another user.",
 		}
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
 	}
 
-	if err := models.AcquireDevice(p.DB, device, userID); err != nil {
+	duration, jsonErr := leaseDuration(p.Cfg, c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	quota, jsonErr := services.RoleQuota(p.DB, p.Cfg, userID)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	// the reservations and the queue are checked along with the acquisition,
+	// under the lock of the device
+	if jsonErr := models.AcquireDevice(p.DB, device, userID, duration, quota); jsonErr != nil {
 		return echo.NewHTTPError(jsonErr.Status, jsonErr)
 	}
 
 	return c.JSON(http.StatusOK, device)
 }
 
+// AcquireByCriteria -
+// @description Set the `User` who made the request as the owner of `count` free devices matching the given filters, for a lease of the given `duration`. A device is free if it is not owned, not missing, not booked by another user during the lease, and not awaited by another user in the queue. Either every requested device is acquired, or none. The request is refused (403) if it would exceed the quota of the user.
+// @id acquireByCriteria
+// @tags device
+// @summary acquire any matching devices
+// @accept json
+// @produce json
+// @security jwt
+// @param RequestBody body models.DeviceCriteria true "The number of devices to acquire, and the optional `tags` (all required), `image`, `model` and `pool` filters"
+// @param duration query string false "How long the devices are leased (e.g. `4h`), defaults to the configured `default_duration`"
+// @success 200 {array} models.Device
+// @router /device/acquire [post]
+func (p *ProvisionerController) AcquireByCriteria(c echo.Context) error {
+	criteria := models.DeviceCriteria{Count: 1}
+	if err := c.Bind(&criteria); err != nil || criteria.Count < 1 {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	duration, jsonErr := leaseDuration(p.Cfg, c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	userID := ExtractIDFromToken(c)
+	quota, jsonErr := services.RoleQuota(p.DB, p.Cfg, userID)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	devices, jsonErr := models.AcquireDevices(p.DB, &criteria, userID, duration, quota)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, devices)
+}
+
+// Renew -
+// @description Extend the lease of the `Device` so that it expires `duration` from now. The number of renewals may be limited by the configured `max_renewals`, and the lease cannot be extended over a reservation of another user, nor beyond the weekly lease hours of the owner's quota (403).
+// @id renew
+// @tags device
+// @summary renew the lease of a device
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `Device` whose lease is renewed"
+// @param duration query string false "How long the lease lasts from now (e.g. `4h`), defaults to the configured `default_duration`"
+// @success 200 {object} models.Lease
+// @router /device/{id}/renew [post]
+func (p *ProvisionerController) Renew(c echo.Context) error {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	device, jsonErr := models.GetDevice(p.DB, int64(id))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if device.Owner == nil {
+		jsonErr := models.JSONError{
+			Status: http.StatusConflict,
+			Error:  "device is not leased.",
+		}
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := FilterIDOrAdmin(c, *device.Owner); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	duration, jsonErr := leaseDuration(p.Cfg, c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	now := time.Now()
+	if jsonErr := models.CheckReservations(p.DB, []int64{device.ID}, now, now.Add(duration), *device.Owner); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	quota, jsonErr := services.RoleQuota(p.DB, p.Cfg, *device.Owner)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	maxRenewals := p.Cfg.Section("lease").Key("max_renewals").MustInt(0)
+	lease, jsonErr := models.RenewLease(p.DB, device, duration, maxRenewals, quota)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, lease)
+}
+
+// leaseDuration returns the duration of a lease given by the `duration`
+// query parameter, or the configured default one, which cannot exceed the
+// configured `max_duration`
+func leaseDuration(cfg *ini.File, c echo.Context) (time.Duration, *models.JSONError) {
+	section := cfg.Section("lease")
+	duration := section.Key("default_duration").MustDuration(24 * time.Hour)
+	maxDuration := section.Key("max_duration").MustDuration(7 * 24 * time.Hour)
+
+	if param := c.QueryParam("duration"); param != "" {
+		parsed, err := time.ParseDuration(param)
+		if err != nil || parsed <= 0 {
+			return 0, &models.JSONError{
+				Status: http.StatusBadRequest,
+				Error:  "duration is not valid.",
+			}
+		}
+		duration = parsed
+	}
+
+	if duration > maxDuration {
+		return 0, &models.JSONError{
+			Status: http.StatusBadRequest,
+			Error:  fmt.Sprintf("duration cannot exceed %s.", maxDuration),
+		}
+	}
+
+	return duration, nil
+}
+
 // Release -
-// @description Remove the `Device`'s ownership from the `User` who made the request.
+// @description Remove the `Device`'s ownership from the `User` who made the request. Depending on the `wipe_on_release` policy, the device can also be shut down and its overlay reset to its pristine state, in which case the wipe runs in the background and the returned `Job` tracks its progress: the device stays leased until the wipe succeeds. The release fails (409) if the device is not leased or changed hands in the meantime. Once free, the device is granted to the first user waiting for it in the queue.
 // @id release
 // @tags device
 // @summary release a device
 // @produce json
 // @security jwt
-// @param	id		path 	int	true		"The device port to release"
+// @param	id		path 	int	true		"The id of the `Device` to release"
+// @param	wipe	query	bool	false		"Wipe the device (only if the policy is `optional`)"
 // @success 200 {object} models.Device
+// @success 202 {object} models.Job
 // @router /device/{id}/release [post]
 func (p *ProvisionerController) Release(c echo.Context) error {
-	port, _ := strconv.Atoi(c.Param("id"))
+	id, _ := strconv.Atoi(c.Param("id"))
 
 	// get the requested `Device`
-	device, jsonErr := models.GetDevice(p.DB, int64(port))
+	device, jsonErr := models.GetDevice(p.DB, int64(id))
 	if jsonErr != nil {
 		return echo.NewHTTPError(jsonErr.Status, jsonErr)
 	}
 
-	if device.Owner != nil {
-		if jsonErr := FilterIDOrAdmin(c, *device.Owner); jsonErr != nil {
+	if device.Owner == nil {
+		jsonErr := models.JSONError{
+			Status: http.StatusConflict,
+			Error:  "device is not leased.",
+		}
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := FilterIDOrAdmin(c, *device.Owner); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	wipe, jsonErr := wipeOnRelease(p.Cfg, c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	// the device stays leased until it is wiped, so that nobody can
+	// acquire it in the meantime
+	if wipe {
+		job, jsonErr := services.SubmitWipe(p.Jobs, p.Providers, p.Provisioner, device, models.EnumLeaseReleased, "", ExtractIDFromToken(c))
+		if jsonErr != nil {
 			return echo.NewHTTPError(jsonErr.Status, jsonErr)
 		}
+
+		return c.JSON(http.StatusAccepted, job)
 	}
 
-	if err := models.ReleaseDevice(p.DB, device); err != nil {
+	if jsonErr := models.ReleaseDevice(p.DB, device, models.EnumLeaseReleased); jsonErr != nil {
 		return echo.NewHTTPError(jsonErr.Status, jsonErr)
 	}
 
+	p.Queue.Process()
 	return c.JSON(http.StatusOK, device)
 }
 
+// wipeOnRelease tells if the released device should be wiped, according to
+// the `wipe_on_release` policy (`never`, `optional` or `always`) and to the
+// `wipe` query parameter
+func wipeOnRelease(cfg *ini.File, c echo.Context) (bool, *models.JSONError) {
+	requested, _ := strconv.ParseBool(c.QueryParam("wipe"))
+	return services.ReadWipePolicy(cfg).Wipe(requested)
+}
+
 // Deploy -
-// @description Configure the PXE boot for the `Device` and reboot it.
+// @description Configure the PXE boot for the `Device` on top of the chosen `Image` and reboot it. Without `image`, the image currently deployed on the device is used again (or the default one). The optional `userData` (SSH keys, users, packages and commands) is applied by the device at its first boot, and the SSH keys of the device's owner are installed for the default user. The deployment runs in the background, its progress can be followed through the returned `Job`.
 // @id deploy
 // @tags device
 // @summary deploy a device
+// @accept json
 // @produce json
 // @security jwt
 // @param id path int true "The device id to deploy"
-// @success	204
+// @param RequestBody body models.DeployDevice false "The id of the `Image` to deploy, and the first boot configuration"
+// @success	202 {object} models.Job
 // @router /device/{id}/deploy [post]
 func (p *ProvisionerController) Deploy(c echo.Context) error {
-	port, _ := strconv.Atoi(c.Param("id"))
+	id, _ := strconv.Atoi(c.Param("id"))
 
 	// get the requested `Device`
-	device, jsonErr := models.GetDevice(p.DB, int64(port))
+	device, jsonErr := models.GetDevice(p.DB, int64(id))
 	if jsonErr != nil {
 		return echo.NewHTTPError(jsonErr.Status, jsonErr)
 	}
@@ -106,24 +295,69 @@ func (p *ProvisionerController) Deploy(c echo.Context) error {
 		}
 	}
 
-	// setup the necessary files and folders for the network boot and deployment
-	cmd := exec.Command("./scripts/deploy-device.sh", device.Hostname)
-	go cmd.Run()
+	deploy := models.DeployDevice{}
+	if err := c.Bind(&deploy); err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
 
-	if device.IsTurnedOn {
-		jsonErr := services.PowerDeviceOff(strconv.FormatInt(int64(port), 10))
-		if jsonErr != nil {
+	image, jsonErr := deployImage(p.DB, device, deploy.Image)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	defaultUser := p.Cfg.Section("provisioning").Key("default_user").MustString("pi")
+	firstBoot, jsonErr := services.DeviceFirstBoot(p.DB, defaultUser, device, deploy.UserData)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+	if firstBoot != nil {
+		if err := firstBoot.Validate(); err != nil {
+			jsonErr := models.JSONError{
+				Status: http.StatusBadRequest,
+				Error:  err.Error(),
+			}
 			return echo.NewHTTPError(jsonErr.Status, jsonErr)
 		}
-		models.SwitchDevicePower(p.DB, device)
 	}
 
-	jsonErr = services.PowerDeviceOn(strconv.FormatInt(int64(port), 10))
-	if jsonErr != nil {
+	job := models.Job{
+		Type:        models.EnumJobDeploy,
+		DeviceID:    &device.ID,
+		RequesterID: ExtractIDFromToken(c),
+	}
+
+	task := services.DeployTask(p.DB, p.Providers, p.Provisioner, device, image, firstBoot)
+	if jsonErr := p.Jobs.Submit(&job, task); jsonErr != nil {
 		return echo.NewHTTPError(jsonErr.Status, jsonErr)
 	}
 
-	models.SwitchDevicePower(p.DB, device)
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// deployImage returns the `Image` to deploy on the `Device`: the one with the
+// given `imageID`, which cannot be retired, or else the one currently
+// deployed on the device. A nil image means the default one.
+func deployImage(db *pg.DB, device *models.Device, imageID *int64) (*models.Image, *models.JSONError) {
+	requested := imageID != nil
+	if !requested {
+		imageID = device.ImageID
+	}
+	if imageID == nil {
+		return nil, nil
+	}
+
+	image, jsonErr := models.GetImage(db, *imageID)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	if image.Retired && requested {
+		return nil, &models.JSONError{
+			Status: http.StatusConflict,
+			Error:  "image is retired.",
+		}
+	}
 
-	return c.NoContent(http.StatusNoContent)
+	return image, nil
 }
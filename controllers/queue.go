@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/labstack/echo/v4"
+	"github.com/xiorcale/rubus-api/models"
+	"github.com/xiorcale/rubus-api/services"
+	"gopkg.in/ini.v1"
+)
+
+// QueueController -
+type QueueController struct {
+	DB    *pg.DB
+	Cfg   *ini.File
+	Queue *services.WaitQueue
+}
+
+// Enqueue -
+// @description Wait for the given `Device`, or for any device matching the given filters, to be free. The waiting users are served in FIFO order: as soon as a matching device is released or its lease expires, it is acquired for the first of them, for a lease of the given `duration`, and a `device-granted` notification is sent to them. A device which is already free is granted right away. Users who would exceed their quota cannot wait (403), and are skipped while they exceed it.
+// @id enqueue
+// @tags queue
+// @summary wait for a device
+// @accept json
+// @produce json
+// @security jwt
+// @param RequestBody body models.NewQueueEntry true "The device to wait for, or the filters it must match"
+// @param duration query string false "How long the granted device is leased (e.g. `4h`), defaults to the configured `default_duration`"
+// @success 201 {object} models.QueueEntry
+// @router /queue [post]
+func (q *QueueController) Enqueue(c echo.Context) error {
+	userID := ExtractIDFromToken(c)
+
+	newEntry := models.NewQueueEntry{}
+	if err := c.Bind(&newEntry); err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if newEntry.Device != nil {
+		device, jsonErr := models.GetDevice(q.DB, *newEntry.Device)
+		if jsonErr != nil {
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+
+		if device.Owner != nil && *device.Owner == userID {
+			jsonErr := models.JSONError{
+				Status: http.StatusConflict,
+				Error:  "device is already owned by you.",
+			}
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+	}
+
+	duration, jsonErr := leaseDuration(q.Cfg, c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := services.CheckQuota(q.DB, q.Cfg, userID, 1, duration); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	entry := models.QueueEntry{
+		UserID:   userID,
+		DeviceID: newEntry.Device,
+		Tags:     newEntry.Tags,
+		ImageID:  newEntry.Image,
+		Model:    newEntry.Model,
+		Pool:     newEntry.Pool,
+		Duration: duration.String(),
+	}
+
+	if jsonErr := models.AddQueueEntry(q.DB, &entry); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	q.Queue.Process()
+
+	added, jsonErr := models.GetQueueEntry(q.DB, entry.ID)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusCreated, added)
+}
+
+// ListQueue -
+// @description List the waiting `QueueEntry` of the `User` who made the request, in FIFO order, along with their position. Administrators see every waiting entry.
+// @id listQueue
+// @tags queue
+// @summary list the waiting entries
+// @produce json
+// @security jwt
+// @success 200 {array} models.QueueEntry "A JSON array listing the waiting entries"
+// @router /queue [get]
+func (q *QueueController) ListQueue(c echo.Context) error {
+	var userID *int64
+	if FilterAdmin(c) != nil {
+		id := ExtractIDFromToken(c)
+		userID = &id
+	}
+
+	entries, jsonErr := models.GetAllQueueEntries(q.DB, userID)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, entries)
+}
+
+// Get -
+// @description Return the `QueueEntry` with the given `id`, along with its position if it is still waiting.
+// @id getQueueEntry
+// @tags queue
+// @summary get a queue entry by id
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `QueueEntry` to get"
+// @success 200 {object} models.QueueEntry
+// @router /queue/{id} [get]
+func (q *QueueController) Get(c echo.Context) error {
+	entry, jsonErr := q.getQueueEntry(c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, entry)
+}
+
+// Cancel -
+// @description Stop waiting, if the `QueueEntry` with the given `id` has not been granted a device yet.
+// @id cancelQueueEntry
+// @tags queue
+// @summary leave the queue
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `QueueEntry` to cancel"
+// @success 200 {object} models.QueueEntry
+// @router /queue/{id} [delete]
+func (q *QueueController) Cancel(c echo.Context) error {
+	entry, jsonErr := q.getQueueEntry(c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := models.CancelQueueEntry(q.DB, entry); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, entry)
+}
+
+// getQueueEntry returns the `QueueEntry` whose id is given in the path, if
+// the `User` who made the request owns it or is an administrator
+func (q *QueueController) getQueueEntry(c echo.Context) (*models.QueueEntry, *models.JSONError) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, models.NewBadRequestError()
+	}
+
+	entry, jsonErr := models.GetQueueEntry(q.DB, int64(id))
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	if jsonErr := FilterIDOrAdmin(c, entry.UserID); jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	return entry, nil
+}
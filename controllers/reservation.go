@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/labstack/echo/v4"
+	"github.com/xiorcale/rubus-api/models"
+	"github.com/xiorcale/rubus-api/services"
+	"gopkg.in/ini.v1"
+)
+
+// ReservationController -
+type ReservationController struct {
+	DB    *pg.DB
+	Cfg   *ini.File
+	Queue *services.WaitQueue
+}
+
+// CreateReservation -
+// @description Book the given devices for the `User` who made the request over a future time window. The reservation is refused if any of the devices is already booked during the window, or still owned by another user when it starts, as well as if the devices leased over the whole window would exceed the quota of the user (403). When the window starts, the devices are acquired for the user until its end, within its quota.
+// @id createReservation
+// @tags reservation
+// @summary book devices
+// @accept json
+// @produce json
+// @security jwt
+// @param RequestBody body models.NewReservation true "The devices to book, and the time window"
+// @success 201 {object} models.Reservation
+// @router /reservation [post]
+func (r *ReservationController) CreateReservation(c echo.Context) error {
+	userID := ExtractIDFromToken(c)
+
+	newReservation := models.NewReservation{}
+	if err := c.Bind(&newReservation); err != nil || len(newReservation.Devices) == 0 {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	startsAt, endsAt := newReservation.StartsAt, newReservation.EndsAt
+	maxDuration := r.Cfg.Section("lease").Key("max_duration").MustDuration(7 * 24 * time.Hour)
+
+	if !startsAt.After(time.Now()) || !endsAt.After(startsAt) {
+		jsonErr := models.JSONError{
+			Status: http.StatusBadRequest,
+			Error:  "time window is not valid.",
+		}
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if endsAt.Sub(startsAt) > maxDuration {
+		jsonErr := models.JSONError{
+			Status: http.StatusBadRequest,
+			Error:  fmt.Sprintf("time window cannot exceed %s.", maxDuration),
+		}
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	deviceIDs := []int64{}
+	seen := map[int64]bool{}
+	for _, deviceID := range newReservation.Devices {
+		if seen[deviceID] {
+			continue
+		}
+		seen[deviceID] = true
+		deviceIDs = append(deviceIDs, deviceID)
+
+		device, jsonErr := models.GetDevice(r.DB, deviceID)
+		if jsonErr != nil {
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+
+		// the current owner must have released the device by then
+		if device.Owner != nil && *device.Owner != userID &&
+			(device.LeaseExpiresAt == nil || device.LeaseExpiresAt.After(startsAt)) {
+			jsonErr := models.JSONError{
+				Status: http.StatusConflict,
+				Error:  fmt.Sprintf("device %d is owned by another user during the time window.", device.ID),
+			}
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+	}
+
+	reservation := models.Reservation{
+		UserID:    userID,
+		DeviceIDs: deviceIDs,
+		StartsAt:  startsAt,
+		EndsAt:    endsAt,
+	}
+
+	quota, jsonErr := services.RoleQuota(r.DB, r.Cfg, userID)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := models.AddReservation(r.DB, &reservation, quota); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusCreated, reservation)
+}
+
+// ListReservation -
+// @description List the pending and active `Reservation` of the `User` who made the request, by start time. Administrators see every reservation. With `device`, the reservations of every user booking this device are listed, so that its free time slots can be found.
+// @id listReservation
+// @tags reservation
+// @summary list the reservations
+// @produce json
+// @security jwt
+// @param device query int false "Only list the reservations booking this `Device`"
+// @param all query bool false "Also list the completed, canceled and failed reservations"
+// @success 200 {array} models.Reservation "A JSON array listing the reservations"
+// @router /reservation [get]
+func (r *ReservationController) ListReservation(c echo.Context) error {
+	var userID, deviceID *int64
+	if param := c.QueryParam("device"); param != "" {
+		id, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			jsonErr := models.NewBadRequestError()
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+		deviceID = &id
+	} else if FilterAdmin(c) != nil {
+		id := ExtractIDFromToken(c)
+		userID = &id
+	}
+
+	all, _ := strconv.ParseBool(c.QueryParam("all"))
+
+	reservations, jsonErr := models.GetAllReservations(r.DB, userID, deviceID, all)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, reservations)
+}
+
+// Get -
+// @description Return the `Reservation` with the given `id`.
+// @id getReservation
+// @tags reservation
+// @summary get a reservation by id
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `Reservation` to get"
+// @success 200 {object} models.Reservation
+// @router /reservation/{id} [get]
+func (r *ReservationController) Get(c echo.Context) error {
+	reservation, jsonErr := r.getReservation(c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, reservation)
+}
+
+// Cancel -
+// @description Cancel the `Reservation` with the given `id`. If its window has already started, the devices acquired for it are released. The devices are then granted to the users waiting for them in the queue.
+// @id cancelReservation
+// @tags reservation
+// @summary cancel a reservation
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `Reservation` to cancel"
+// @success 200 {object} models.Reservation
+// @router /reservation/{id} [delete]
+func (r *ReservationController) Cancel(c echo.Context) error {
+	reservation, jsonErr := r.getReservation(c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if reservation.IsDone() {
+		jsonErr := models.JSONError{
+			Status: http.StatusConflict,
+			Error:  "reservation is already over.",
+		}
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if reservation.Status == models.EnumReservationActive {
+		for _, deviceID := range reservation.DeviceIDs {
+			device, jsonErr := models.GetDevice(r.DB, deviceID)
+			if jsonErr != nil {
+				continue
+			}
+			if device.Owner == nil || *device.Owner != reservation.UserID {
+				continue
+			}
+			if jsonErr := models.ReleaseDevice(r.DB, device, models.EnumLeaseReleased); jsonErr != nil {
+				return echo.NewHTTPError(jsonErr.Status, jsonErr)
+			}
+		}
+	}
+
+	reservation.Status = models.EnumReservationCanceled
+	if jsonErr := models.UpdateReservationStatus(r.DB, reservation); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	// the released and no longer booked devices can be granted to the
+	// users waiting for them
+	r.Queue.Process()
+
+	return c.JSON(http.StatusOK, reservation)
+}
+
+// getReservation returns the `Reservation` whose id is given in the path, if
+// the `User` who made the request made it or is an administrator
+func (r *ReservationController) getReservation(c echo.Context) (*models.Reservation, *models.JSONError) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, models.NewBadRequestError()
+	}
+
+	reservation, jsonErr := models.GetReservation(r.DB, int64(id))
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	if jsonErr := FilterIDOrAdmin(c, reservation.UserID); jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	return reservation, nil
+}
@@ -0,0 +1,281 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/labstack/echo/v4"
+	"github.com/xiorcale/rubus-api/models"
+	"github.com/xiorcale/rubus-api/provisioning"
+	"github.com/xiorcale/rubus-api/services"
+	"gopkg.in/ini.v1"
+)
+
+// SnapshotController -
+type SnapshotController struct {
+	DB          *pg.DB
+	Cfg         *ini.File
+	Providers   *services.ProviderRegistry
+	Jobs        *services.JobRunner
+	Provisioner *provisioning.Provisioner
+}
+
+// CreateSnapshot -
+// @description Archive the overlay of the `Device` with the given `id`, i.e. everything written on top of its image. The device is shut down while its overlay is archived, and booted again afterwards if it was running. The device must be owned by the `User` who made the request, unless they are an administrator. The snapshot runs in the background, its progress can be followed through the returned `Job`.
+// @id createSnapshot
+// @tags snapshot
+// @summary snapshot a device
+// @accept json
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `Device` to snapshot"
+// @param RequestBody body models.NewSnapshot true "The `name` is required."
+// @success 202 {object} models.Job
+// @router /device/{id}/snapshot [post]
+func (s *SnapshotController) CreateSnapshot(c echo.Context) error {
+	id, _ := strconv.Atoi(c.Param("id"))
+	userID := ExtractIDFromToken(c)
+
+	device, jsonErr := models.GetDevice(s.DB, int64(id))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := FilterOwnerOrAdmin(c, device); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	newSnapshot := models.NewSnapshot{}
+	if err := c.Bind(&newSnapshot); err != nil || newSnapshot.Name == "" {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := s.checkQuota(userID); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	var image *models.Image
+	if device.ImageID != nil {
+		if image, jsonErr = models.GetImage(s.DB, *device.ImageID); jsonErr != nil {
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+	}
+
+	snapshot := models.Snapshot{
+		Name:        newSnapshot.Name,
+		Description: newSnapshot.Description,
+		DeviceID:    device.ID,
+		OwnerID:     userID,
+		ImageID:     device.ImageID,
+		Path:        s.Provisioner.SnapshotPath(fmt.Sprintf("%s-%d", device.Hostname, time.Now().UnixNano())),
+	}
+
+	job := models.Job{
+		Type:        models.EnumJobSnapshot,
+		DeviceID:    &device.ID,
+		RequesterID: userID,
+	}
+
+	task := services.SnapshotTask(s.DB, s.Providers, s.Provisioner, device, image, &snapshot, services.ReadSnapshotQuota(s.Cfg))
+	if jsonErr := s.Jobs.Submit(&job, task); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// ListDeviceSnapshot -
+// @description List the `Snapshot` taken from the `Device` with the given `id` by the `User` who made the request, most recent first. Administrators see every snapshot.
+// @id listDeviceSnapshot
+// @tags snapshot
+// @summary list the snapshots of a device
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `Device`"
+// @success 200 {array} models.Snapshot "A JSON array listing the snapshots"
+// @router /device/{id}/snapshot [get]
+func (s *SnapshotController) ListDeviceSnapshot(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	deviceID := int64(id)
+	snapshots, jsonErr := models.GetAllSnapshots(s.DB, s.ownerFilter(c), &deviceID)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, snapshots)
+}
+
+// ListSnapshot -
+// @description List the `Snapshot` of the `User` who made the request, most recent first. Administrators see every snapshot.
+// @id listSnapshot
+// @tags snapshot
+// @summary list the snapshots
+// @produce json
+// @security jwt
+// @success 200 {array} models.Snapshot "A JSON array listing the snapshots"
+// @router /snapshot [get]
+func (s *SnapshotController) ListSnapshot(c echo.Context) error {
+	snapshots, jsonErr := models.GetAllSnapshots(s.DB, s.ownerFilter(c), nil)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, snapshots)
+}
+
+// Get -
+// @description Return the `Snapshot` with the given `id`.
+// @id getSnapshot
+// @tags snapshot
+// @summary get a snapshot by id
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `Snapshot` to get"
+// @success 200 {object} models.Snapshot
+// @router /snapshot/{id} [get]
+func (s *SnapshotController) Get(c echo.Context) error {
+	snapshot, jsonErr := s.getSnapshot(c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, snapshot)
+}
+
+// Restore -
+// @description Replace the overlay of a `Device` by the `Snapshot` with the given `id`, on top of the image the snapshot was taken on. Without `device`, the snapshot is restored on the device it was taken from. The device must be owned by the `User` who made the request, unless they are an administrator. It is shut down, and must be deployed again afterwards. The restoration runs in the background, its progress can be followed through the returned `Job`.
+// @id restoreSnapshot
+// @tags snapshot
+// @summary restore a snapshot
+// @accept json
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `Snapshot` to restore"
+// @param RequestBody body models.RestoreSnapshot false "The id of the `Device` to restore the snapshot on"
+// @success 202 {object} models.Job
+// @router /snapshot/{id}/restore [post]
+func (s *SnapshotController) Restore(c echo.Context) error {
+	snapshot, jsonErr := s.getSnapshot(c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	restore := models.RestoreSnapshot{}
+	if err := c.Bind(&restore); err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	deviceID := snapshot.DeviceID
+	if restore.Device != nil {
+		deviceID = *restore.Device
+	}
+
+	device, jsonErr := models.GetDevice(s.DB, deviceID)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := FilterOwnerOrAdmin(c, device); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	var image *models.Image
+	if snapshot.ImageID != nil {
+		if image, jsonErr = models.GetImage(s.DB, *snapshot.ImageID); jsonErr != nil {
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+	}
+
+	job := models.Job{
+		Type:        models.EnumJobRestore,
+		DeviceID:    &device.ID,
+		RequesterID: ExtractIDFromToken(c),
+	}
+
+	task := services.RestoreTask(s.DB, s.Providers, s.Provisioner, device, snapshot, image)
+	if jsonErr := s.Jobs.Submit(&job, task); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// Delete -
+// @description Delete the `Snapshot` with the given `id`, along with its archive.
+// @id deleteSnapshot
+// @tags snapshot
+// @summary delete a snapshot
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `Snapshot` to delete"
+// @success 204
+// @router /snapshot/{id} [delete]
+func (s *SnapshotController) Delete(c echo.Context) error {
+	snapshot, jsonErr := s.getSnapshot(c)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if err := s.Provisioner.DeleteSnapshot(snapshot.Path); err != nil {
+		jsonErr := models.NewInternalServerError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := models.DeleteSnapshot(s.DB, snapshot.ID); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// getSnapshot returns the `Snapshot` whose id is given in the path, if the
+// `User` who made the request owns it or is an administrator
+func (s *SnapshotController) getSnapshot(c echo.Context) (*models.Snapshot, *models.JSONError) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, models.NewBadRequestError()
+	}
+
+	snapshot, jsonErr := models.GetSnapshot(s.DB, int64(id))
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	if jsonErr := FilterIDOrAdmin(c, snapshot.OwnerID); jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	return snapshot, nil
+}
+
+// ownerFilter returns the id of the `User` who made the request, or nil if
+// they are an administrator
+func (s *SnapshotController) ownerFilter(c echo.Context) *int64 {
+	if FilterAdmin(c) == nil {
+		return nil
+	}
+
+	id := ExtractIDFromToken(c)
+	return &id
+}
+
+// checkQuota makes sure the `User` with the given `userID` can take another
+// snapshot without exceeding the `SnapshotQuota`
+func (s *SnapshotController) checkQuota(userID int64) *models.JSONError {
+	count, size, jsonErr := models.GetSnapshotUsage(s.DB, userID)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	return services.ReadSnapshotQuota(s.Cfg).Check(count, size, 0)
+}
@@ -2,17 +2,23 @@ package controllers
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/go-pg/pg/v9"
 	"github.com/labstack/echo/v4"
 	"github.com/xiorcale/rubus-api/models"
+	"github.com/xiorcale/rubus-api/provisioning"
+	"github.com/xiorcale/rubus-api/services"
 	"gopkg.in/ini.v1"
 )
 
 // UserController -
 type UserController struct {
-	DB  *pg.DB
-	Cfg *ini.File
+	DB          *pg.DB
+	Cfg         *ini.File
+	Providers   *services.ProviderRegistry
+	Provisioner *provisioning.Provisioner
+	Queue       *services.WaitQueue
 }
 
 // GetMe -
@@ -64,7 +70,7 @@ func (u *UserController) UpdateMe(c echo.Context) error {
 }
 
 // DeleteMe -
-// @description Delete the `User` who made the request.
+// @description Delete the `User` who made the request, along with their SSH keys, notifications and snapshots. The devices they own are released, shut down and granted to the users waiting for them, and their waiting queue entries and reservations are canceled.
 // @id deleteMe
 // @tags user
 // @summary delete the autenticated user
@@ -74,7 +80,214 @@ func (u *UserController) UpdateMe(c echo.Context) error {
 func (u *UserController) DeleteMe(c echo.Context) error {
 	id := ExtractIDFromToken(c)
 
-	if jsonErr := models.DeleteUser(u.DB, id); jsonErr != nil {
+	if jsonErr := services.DeleteUser(u.DB, u.Providers, u.Provisioner, u.Queue, id); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetQuota -
+// @description Return the `Quota` of the `User` who made the request, along with its usage: the number of devices currently owned, and the lease hours over the last 7 days, counting the active leases up to their expiration.
+// @id getQuota
+// @tags user
+// @summary get the quota of the authenticated user
+// @produce json
+// @security jwt
+// @success 200 {object} models.QuotaUsage
+// @router /user/me/quota [get]
+func (u *UserController) GetQuota(c echo.Context) error {
+	usage, jsonErr := services.GetQuotaUsage(u.DB, u.Cfg, ExtractIDFromToken(c))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, usage)
+}
+
+// ListKey -
+// @description List the SSH public keys of the `User` who made the request. They are installed on every device the user deploys.
+// @id listKey
+// @tags user
+// @summary list the ssh keys of the authenticated user
+// @produce json
+// @security jwt
+// @success 200 {array} models.SSHKey "A JSON array listing the ssh keys"
+// @router /user/me/keys [get]
+func (u *UserController) ListKey(c echo.Context) error {
+	keys, jsonErr := models.GetSSHKeysByUser(u.DB, ExtractIDFromToken(c))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, keys)
+}
+
+// AddKey -
+// @description Add an SSH public key, in the `authorized_keys` format, to the `User` who made the request. Without `name`, the comment of the key is used.
+// @id addKey
+// @tags user
+// @summary add an ssh key to the authenticated user
+// @accept json
+// @produce json
+// @security jwt
+// @param RequestBody body models.NewSSHKey true "The `publicKey` is required."
+// @success 201 {object} models.SSHKey
+// @router /user/me/keys [post]
+func (u *UserController) AddKey(c echo.Context) error {
+	newKey := models.NewSSHKey{}
+	if err := c.Bind(&newKey); err != nil || newKey.PublicKey == "" {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	key, jsonErr := models.ParseSSHKey(newKey.Name, newKey.PublicKey)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+	key.UserID = ExtractIDFromToken(c)
+
+	if jsonErr := models.AddSSHKey(u.DB, key); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusCreated, key)
+}
+
+// GetKey -
+// @description Return the SSH public key with the given `id` of the `User` who made the request.
+// @id getKey
+// @tags user
+// @summary get an ssh key of the authenticated user
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `SSHKey` to get"
+// @success 200 {object} models.SSHKey
+// @router /user/me/keys/{id} [get]
+func (u *UserController) GetKey(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	key, jsonErr := models.GetSSHKey(u.DB, ExtractIDFromToken(c), int64(id))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, key)
+}
+
+// UpdateKey -
+// @description Rename the SSH public key with the given `id` of the `User` who made the request.
+// @id updateKey
+// @tags user
+// @summary rename an ssh key of the authenticated user
+// @accept json
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `SSHKey` to rename"
+// @param RequestBody body models.PutSSHKey true "The new name of the key"
+// @success 200 {object} models.SSHKey
+// @router /user/me/keys/{id} [put]
+func (u *UserController) UpdateKey(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	putKey := models.PutSSHKey{}
+	if err := c.Bind(&putKey); err != nil || putKey.Name == "" {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	key, jsonErr := models.GetSSHKey(u.DB, ExtractIDFromToken(c), int64(id))
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	key.Name = putKey.Name
+	if jsonErr := models.RenameSSHKey(u.DB, key); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, key)
+}
+
+// DeleteKey -
+// @description Delete the SSH public key with the given `id` of the `User` who made the request. The key is not removed from the devices already deployed.
+// @id deleteKey
+// @tags user
+// @summary delete an ssh key of the authenticated user
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `SSHKey` to delete"
+// @success 204
+// @router /user/me/keys/{id} [delete]
+func (u *UserController) DeleteKey(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := models.DeleteSSHKey(u.DB, ExtractIDFromToken(c), int64(id)); jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListNotification -
+// @description List the notifications of the `User` who made the request, most recent first. With `since`, only the notifications more recent than the one with this id are listed, so that they can be polled.
+// @id listNotification
+// @tags user
+// @summary list the notifications of the authenticated user
+// @produce json
+// @security jwt
+// @param since query int false "Only list the notifications whose id is greater than this one"
+// @success 200 {array} models.Notification "A JSON array listing the notifications"
+// @router /user/me/notifications [get]
+func (u *UserController) ListNotification(c echo.Context) error {
+	var since int64
+	if param := c.QueryParam("since"); param != "" {
+		id, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			jsonErr := models.NewBadRequestError()
+			return echo.NewHTTPError(jsonErr.Status, jsonErr)
+		}
+		since = id
+	}
+
+	notifications, jsonErr := models.GetNotificationsByUser(u.DB, ExtractIDFromToken(c), since)
+	if jsonErr != nil {
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	return c.JSON(http.StatusOK, notifications)
+}
+
+// DeleteNotification -
+// @description Dismiss the notification with the given `id` of the `User` who made the request.
+// @id deleteNotification
+// @tags user
+// @summary dismiss a notification of the authenticated user
+// @produce json
+// @security jwt
+// @param id path int true "The id of the `Notification` to dismiss"
+// @success 204
+// @router /user/me/notifications/{id} [delete]
+func (u *UserController) DeleteNotification(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonErr := models.NewBadRequestError()
+		return echo.NewHTTPError(jsonErr.Status, jsonErr)
+	}
+
+	if jsonErr := models.DeleteNotification(u.DB, ExtractIDFromToken(c), int64(id)); jsonErr != nil {
 		return echo.NewHTTPError(jsonErr.Status, jsonErr)
 	}
 
@@ -43,3 +43,13 @@ func FilterIDOrAdmin(c echo.Context, id int64) *models.JSONError {
 
 	return nil
 }
+
+// FilterOwnerOrAdmin checks if the `User` owns the given `Device` or is an
+// admin. If not, return an Unauthorized `JSONError`.
+func FilterOwnerOrAdmin(c echo.Context, device *models.Device) *models.JSONError {
+	if device.Owner == nil {
+		return FilterAdmin(c)
+	}
+
+	return FilterIDOrAdmin(c, *device.Owner)
+}
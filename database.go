@@ -10,12 +10,23 @@ import (
 
 var modelsList = []interface{}{
 	(*models.User)(nil),
+	(*models.DevicePool)(nil),
 	(*models.Device)(nil),
+	(*models.Provider)(nil),
+	(*models.Job)(nil),
+	(*models.Image)(nil),
+	(*models.Snapshot)(nil),
+	(*models.SSHKey)(nil),
+	(*models.Lease)(nil),
+	(*models.Reservation)(nil),
+	(*models.QueueEntry)(nil),
+	(*models.Notification)(nil),
+	(*models.Batch)(nil),
 }
 
 func createSchema(db *pg.DB) error {
 	for _, model := range modelsList {
-		if err := db.CreateTable(model, &orm.CreateTableOptions{IfNotExists: true}); err != nil {
+		if err := db.CreateTable(model, &orm.CreateTableOptions{IfNotExists: true, FKConstraints: true}); err != nil {
 			return err
 		}
 	}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"errors"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/go-pg/pg/v9"
 	"github.com/labstack/echo/v4"
+	"github.com/xiorcale/rubus-api/models"
+	"github.com/xiorcale/rubus-api/provisioning"
+	"github.com/xiorcale/rubus-api/services"
 	"gopkg.in/ini.v1"
 )
 
@@ -33,11 +39,31 @@ import (
 // @tag.description Operations about devices, such as provisioning or deployment
 // @tag.name user
 // @tag.description Operations about Users
+// @tag.name image
+// @tag.description Operations about the operating system images which can be deployed
+// @tag.name job
+// @tag.description Operations about long-running jobs, such as deployments
+// @tag.name pool
+// @tag.description Operations about the device pools, and the batch operations on their devices
+// @tag.name queue
+// @tag.description Operations about waiting for busy devices
+// @tag.name reservation
+// @tag.description Operations about the booking of devices in advance
+// @tag.name snapshot
+// @tag.description Operations about the snapshots of the devices' overlay
 
 type server struct {
-	e   *echo.Echo
-	db  *pg.DB
-	cfg *ini.File
+	e           *echo.Echo
+	db          *pg.DB
+	cfg         *ini.File
+	providers   *services.ProviderRegistry
+	syncer      *services.Syncer
+	jobs        *services.JobRunner
+	provisioner *provisioning.Provisioner
+	queue       *services.WaitQueue
+	reaper      *services.LeaseReaper
+	scheduler   *services.ReservationScheduler
+	expirer     *services.UserExpirer
 }
 
 func main() {
@@ -55,22 +81,133 @@ func main() {
 	})
 	defer s.db.Close()
 
-	if recreate, _ := dbCfg.Key("recreate").Bool(); recreate {
+	recreate, _ := dbCfg.Key("recreate").Bool()
+	if recreate {
 		log.Printf("Recreate database schemas")
 		if err := deleteSchema(s.db); err != nil {
 			panic(err)
 		}
-		if err := createSchema(s.db); err != nil {
-			panic(err)
-		}
+	}
+
+	// tables are created only if they do not exist yet
+	if err := createSchema(s.db); err != nil {
+		panic(err)
+	}
+
+	if recreate {
 		if err := createAdmin(s); err != nil {
 			panic(err)
 		}
 	}
 
+	// init providers
+	s.providers = services.NewProviderRegistry()
+	if err := registerProviders(s); err != nil {
+		panic(err)
+	}
+
+	// init the background synchronization with the providers
+	syncCfg := s.cfg.Section("sync")
+	interval := syncCfg.Key("interval").MustDuration(time.Minute)
+	bootDuration := syncCfg.Key("boot_duration").MustDuration(2 * time.Minute)
+	s.syncer = services.NewSyncer(s.db, s.providers, interval, bootDuration)
+	s.syncer.Start()
+
+	// init the background jobs, which cannot survive a restart
+	if jsonErr := models.FailInterruptedJobs(s.db); jsonErr != nil {
+		panic(jsonErr.Error)
+	}
+	s.jobs = services.NewJobRunner(s.db)
+
+	// init the network boot provisioning
+	s.provisioner = provisioning.NewProvisioner(readProvisioningConfig(s.cfg.Section("provisioning")))
+
+	// init the background grant of the free devices to the waiting users
+	s.queue = services.NewWaitQueue(s.db, s.cfg, s.jobs,
+		s.cfg.Section("queue").Key("interval").MustDuration(30*time.Second))
+	s.jobs.DeviceFree = func(int64) { s.queue.Process() }
+	s.queue.Start()
+
+	// init the background release of the expired leases
+	leaseCfg := s.cfg.Section("lease")
+	s.reaper = services.NewLeaseReaper(s.db, s.providers, s.jobs, s.provisioner, s.queue,
+		leaseCfg.Key("reaper_interval").MustDuration(time.Minute),
+		services.ExpiryAction(leaseCfg.Key("on_expiry").In(string(services.EnumExpiryPowerOff), []string{
+			string(services.EnumExpiryRelease),
+			string(services.EnumExpiryPowerOff),
+			string(services.EnumExpiryWipe),
+		})),
+		services.ReadWipePolicy(s.cfg))
+	s.reaper.Start()
+
+	// init the background start of the reservations
+	s.scheduler = services.NewReservationScheduler(s.db, s.cfg,
+		s.cfg.Section("reservation").Key("interval").MustDuration(time.Minute))
+	s.scheduler.Start()
+
+	// init the background release of the devices of the expired users
+	expiryCfg := s.cfg.Section("expiry")
+	s.expirer = services.NewUserExpirer(s.db, s.providers, s.jobs, s.provisioner, s.queue,
+		expiryCfg.Key("interval").MustDuration(time.Hour),
+		expiryCfg.Key("power_off").MustBool(true),
+		services.ReadWipePolicy(s.cfg))
+	s.expirer.Start()
+
 	// init REST API
 	s.e = echo.New()
 	createRESTEndpoints(s)
 
 	s.e.Logger.Fatal(s.e.Start(":1323"))
 }
+
+// registerProviders fills the provider registry with the providers defined in
+// the configuration file, followed by the ones registered by administrators
+func registerProviders(s server) error {
+	section := s.cfg.Section("provider")
+	sections := append([]*ini.Section{section}, section.ChildSections()...)
+
+	for _, sec := range sections {
+		name := services.DefaultProviderName
+		if sec != section {
+			name = strings.TrimPrefix(sec.Name(), "provider.")
+		}
+
+		cfg := services.ReadHTTPProviderConfig(sec)
+		p, err := services.NewHTTPProvider(cfg)
+		if err != nil {
+			return err
+		}
+		s.providers.Register(name, cfg.BaseURL, true, p)
+	}
+
+	providers, jsonErr := models.GetAllProviders(s.db)
+	if jsonErr != nil {
+		return errors.New(jsonErr.Error)
+	}
+
+	defaults := services.ReadHTTPProviderConfig(section)
+	for _, provider := range *providers {
+		if err := s.providers.RegisterProvider(defaults, &provider); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readProvisioningConfig returns the `provisioning.Config` described by the
+// given configuration section, using the defaults for the missing keys
+func readProvisioningConfig(section *ini.Section) provisioning.Config {
+	cfg := provisioning.DefaultConfig()
+
+	cfg.NFSRoot = section.Key("nfs_root").MustString(cfg.NFSRoot)
+	cfg.TFTPRoot = section.Key("tftp_root").MustString(cfg.TFTPRoot)
+	cfg.ExportsFile = section.Key("exports_file").MustString(cfg.ExportsFile)
+	cfg.ExportOptions = section.Key("export_options").MustString(cfg.ExportOptions)
+	cfg.NFSServer = section.Key("nfs_server").MustString(cfg.NFSServer)
+	cfg.TemplateUpper = section.Key("template_upper").MustString(cfg.TemplateUpper)
+	cfg.TemplateLower = section.Key("template_lower").MustString(cfg.TemplateLower)
+	cfg.SnapshotDir = section.Key("snapshot_dir").MustString(cfg.SnapshotDir)
+
+	return cfg
+}
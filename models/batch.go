@@ -0,0 +1,153 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// BatchOperation is an enum which specify the operation performed by a
+// `Batch` on every device of a `DevicePool`
+type BatchOperation string
+
+// Values for `BatchOperation` enum
+const (
+	EnumBatchPowerOn  BatchOperation = "power-on"
+	EnumBatchPowerOff BatchOperation = "power-off"
+	EnumBatchDeploy   BatchOperation = "deploy"
+	EnumBatchAcquire  BatchOperation = "acquire"
+	EnumBatchRelease  BatchOperation = "release"
+)
+
+// BatchResult is the outcome of a `Batch` on one `Device`. When the
+// operation runs in the background, it is tracked by the `Job` with the
+// given `JobID`.
+type BatchResult struct {
+	DeviceID int64     `json:"deviceId" example:"1"`
+	Status   JobStatus `json:"status" example:"succeeded"`
+	Error    string    `json:"error"`
+	JobID    *int64    `json:"jobId" example:"1"`
+}
+
+// Batch is an operation performed on every device of a `DevicePool`, along
+// with its result on each of them. Its `Status` is the worst of the results:
+// running while any of them is, failed if any of them failed.
+type Batch struct {
+	ID          int64          `json:"id" pg:",pk" example:"1"`
+	Operation   BatchOperation `json:"operation" pg:",notnull" example:"power-on"`
+	Pool        string         `json:"pool" pg:",notnull" example:"rack-1"`
+	RequesterID int64          `json:"requesterId" example:"1"`
+	Status      JobStatus      `json:"status" pg:"-" example:"succeeded"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	Results     []BatchResult  `json:"results"`
+}
+
+// AddBatch inserts a new `Batch` into the database
+func AddBatch(db *pg.DB, batch *Batch) *JSONError {
+	batch.CreatedAt = time.Now()
+
+	if err := db.Insert(batch); err != nil {
+		return NewInternalServerError()
+	}
+
+	return refreshBatch(db, batch)
+}
+
+// UpdateBatchResults saves the `Results` of the given `Batch`
+func UpdateBatchResults(db *pg.DB, batch *Batch) *JSONError {
+	if _, err := db.Model(batch).Column("results").WherePK().Update(); err != nil {
+		return NewInternalServerError()
+	}
+
+	return refreshBatch(db, batch)
+}
+
+// GetBatch returns the `Batch` with the given `batchID` from the database
+func GetBatch(db *pg.DB, batchID int64) (*Batch, *JSONError) {
+	batch := &Batch{ID: batchID}
+	if err := db.Select(batch); err != nil {
+		if err == pg.ErrNoRows {
+			return nil, &JSONError{
+				Status: http.StatusNotFound,
+				Error:  "batch does not exist.",
+			}
+		}
+		return nil, NewInternalServerError()
+	}
+
+	if jsonErr := refreshBatch(db, batch); jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	return batch, nil
+}
+
+// GetAllBatches returns all the `Batch` from the database, most recent
+// first. If `requesterID` is not nil, only the batches requested by this
+// `User` are returned, and if `pool` is not empty, only the ones performed
+// on this `DevicePool`.
+func GetAllBatches(db *pg.DB, requesterID *int64, pool string) (*[]Batch, *JSONError) {
+	batches := &[]Batch{}
+	query := db.Model(batches).Order("id DESC")
+	if requesterID != nil {
+		query = query.Where("requester_id = ?", *requesterID)
+	}
+	if pool != "" {
+		query = query.Where("pool = ?", pool)
+	}
+
+	if err := query.Select(); err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	for i := range *batches {
+		if jsonErr := refreshBatch(db, &(*batches)[i]); jsonErr != nil {
+			return nil, jsonErr
+		}
+	}
+
+	return batches, nil
+}
+
+// refreshBatch updates the results tracked by a `Job` with the status of the
+// job, and the `Status` of the `Batch` with its results
+func refreshBatch(db *pg.DB, batch *Batch) *JSONError {
+	jobIDs := []int64{}
+	for _, result := range batch.Results {
+		if result.JobID != nil {
+			jobIDs = append(jobIDs, *result.JobID)
+		}
+	}
+
+	if len(jobIDs) > 0 {
+		jobs := []Job{}
+		if err := db.Model(&jobs).Where("id IN (?)", pg.In(jobIDs)).Select(); err != nil {
+			return NewInternalServerError()
+		}
+
+		for i := range batch.Results {
+			result := &batch.Results[i]
+			for _, job := range jobs {
+				if result.JobID != nil && *result.JobID == job.ID {
+					result.Status = job.Status
+					result.Error = job.Error
+				}
+			}
+		}
+	}
+
+	batch.Status = EnumJobSucceeded
+	for _, result := range batch.Results {
+		switch result.Status {
+		case EnumJobPending, EnumJobRunning:
+			batch.Status = EnumJobRunning
+		case EnumJobFailed, EnumJobCanceled:
+			if batch.Status != EnumJobRunning {
+				batch.Status = EnumJobFailed
+			}
+		}
+	}
+
+	return nil
+}
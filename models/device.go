@@ -1,26 +1,167 @@
 package models
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
 )
 
-// Device contains the information about a device
+// PowerState is an enum which specify the power state of a `Device`
+type PowerState string
+
+// Values for `PowerState` enum
+const (
+	EnumPowerOn      PowerState = "on"
+	EnumPowerOff     PowerState = "off"
+	EnumPowerBooting PowerState = "booting"
+	EnumPowerUnknown PowerState = "unknown"
+	EnumPowerError   PowerState = "error"
+)
+
+// Device contains the information about a device. The `ID` is a stable
+// surrogate key, while `Provider`, `Port` and `Address` describe where the
+// device is currently plugged, so that re-cabling it does not change its
+// identity. The `Hostname` is unique, since it names the directories the
+// device boots from.
 type Device struct {
-	ID         int64  `json:"id" pg:",pk"`
-	Hostname   string `json:"hostname"`
-	IsTurnedOn bool   `json:"isTurnedOn"`
-	Owner      *int64 `json:"owner" orm:"null"`
+	ID        int64  `json:"id" pg:",pk"`
+	Hostname  string `json:"hostname" pg:",unique"`
+	Provider  string `json:"provider" pg:",notnull,unique:provider_port"`
+	Port      int64  `json:"port" pg:",use_zero,unique:provider_port"`
+	Address   string `json:"address"`
+	IsMissing bool   `json:"isMissing" pg:",use_zero"`
+	Owner     *int64 `json:"owner" orm:"null" pg:"on_delete:SET NULL"`
+	ImageID   *int64 `json:"imageId"`
+
+	// OwnerUser only declares the foreign key of `Owner`, it is never loaded
+	OwnerUser *User `json:"-" pg:"fk:owner"`
+
+	Tags  []string `json:"tags" pg:",array" example:"camera,sense-hat"`
+	Model string   `json:"model" example:"Raspberry Pi 4 Model B"`
+	Pool  string   `json:"pool" pg:"on_delete:SET NULL" example:"rack-1"`
+
+	// PoolRef only declares the foreign key of `Pool`, it is never loaded
+	PoolRef *DevicePool `json:"-" pg:"fk:pool"`
+
+	LeaseExpiresAt *time.Time `json:"leaseExpiresAt"`
+
+	PowerState          PowerState `json:"powerState" pg:",notnull" example:"on"`
+	PowerStateChangedAt time.Time  `json:"powerStateChangedAt"`
+}
+
+// PutDevice is the model sent to move a `Device` to another provider and/or
+// port. Only the given fields are updated.
+type PutDevice struct {
+	Provider *string `json:"provider" example:"default"`
+	Port     *int64  `json:"port" example:"11"`
+	Address  *string `json:"address" example:"0/11"`
+}
+
+// PutDeviceLabels is the model sent to describe a `Device`. Only the given
+// fields are updated.
+type PutDeviceLabels struct {
+	Tags  *[]string `json:"tags" example:"camera,sense-hat"`
+	Model *string   `json:"model" example:"Raspberry Pi 4 Model B"`
+	Pool  *string   `json:"pool" example:"rack-1"`
+}
+
+// DeviceCriteria is the model sent to acquire any `Device` matching the given
+// filters. Only the given filters are applied.
+type DeviceCriteria struct {
+	Count int      `json:"count" example:"2"`
+	Tags  []string `json:"tags" example:"camera"`
+	Image *int64   `json:"image" example:"1"`
+	Model string   `json:"model" example:"Raspberry Pi 4 Model B"`
+	Pool  string   `json:"pool" example:"rack-1"`
+	// IDs restricts the acquisition to the devices with these ids
+	IDs []int64 `json:"-"`
+}
+
+// DeviceRevocation is the model sent by an administrator to force the
+// release of a `Device`
+type DeviceRevocation struct {
+	Reason string `json:"reason" example:"device is stuck."`
+}
+
+// DeviceTransfer is the model sent by an administrator to give a `Device` to
+// another `User`. Unless `Force` is set, the transfer must not exceed the
+// quota of the user nor overlap a reservation of another user.
+type DeviceTransfer struct {
+	User   int64  `json:"user" example:"2"`
+	Reason string `json:"reason" example:"project handed over."`
+	Force  bool   `json:"force" example:"false"`
+}
+
+// DeployDevice is the model sent to deploy a `Device`
+type DeployDevice struct {
+	Image    *int64    `json:"image" example:"1"`
+	UserData *UserData `json:"userData"`
+}
+
+// UserData is the configuration applied by a `Device` at its first boot
+// after a deployment
+type UserData struct {
+	SSHAuthorizedKeys []string       `json:"sshAuthorizedKeys" example:"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIH1qtV5W4Hq8wR1Rvo6yTUZlG3xXTxo1LFmVqxMqT2hA alice@laptop"`
+	Users             []UserDataUser `json:"users"`
+	Packages          []string       `json:"packages" example:"git,vim"`
+	RunCmd            []string       `json:"runcmd" example:"systemctl enable --now docker"`
+}
+
+// UserDataUser is a user created on a `Device` at its first boot
+type UserDataUser struct {
+	Name              string   `json:"name" example:"alice"`
+	Groups            []string `json:"groups" example:"docker"`
+	Sudo              bool     `json:"sudo"`
+	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys" example:"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIH1qtV5W4Hq8wR1Rvo6yTUZlG3xXTxo1LFmVqxMqT2hA alice@laptop"`
+}
+
+// ProviderAddress returns the identifier used by the `Device`'s provider to
+// address it: the provider-specific `Address` if any, the `Port` otherwise.
+func (d *Device) ProviderAddress() string {
+	if d.Address != "" {
+		return d.Address
+	}
+	return strconv.FormatInt(d.Port, 10)
+}
+
+// SetPowerState changes the `PowerState` of the `Device` and records the time
+// of the transition. It returns false if the state did not change.
+func (d *Device) SetPowerState(state PowerState) bool {
+	if d.PowerState == state {
+		return false
+	}
+
+	d.PowerState = state
+	d.PowerStateChangedAt = time.Now()
+	return true
 }
 
 // AddDevice inserts a new `Device` into the database
 func AddDevice(db *pg.DB, d *Device) *JSONError {
+	return addDevice(db, d)
+}
+
+func addDevice(db orm.DB, d *Device) *JSONError {
+	if d.PowerState == "" {
+		d.SetPowerState(EnumPowerUnknown)
+	}
+
 	if err := db.Insert(d); err != nil {
 		if pgErr, ok := err.(pg.Error); ok && pgErr.IntegrityViolation() {
+			if pgErr.Field('n') == "devices_hostname_key" {
+				return &JSONError{
+					Status: http.StatusConflict,
+					Error:  "a device already has this hostname.",
+				}
+			}
 			return &JSONError{
 				Status: http.StatusConflict,
-				Error:  "id already exists.",
+				Error:  "a device is already plugged on this provider port.",
 			}
 		}
 		return NewInternalServerError()
@@ -29,14 +170,34 @@ func AddDevice(db *pg.DB, d *Device) *JSONError {
 	return nil
 }
 
-// AddDeviceMulti insters multiple `Device` into the database
-func AddDeviceMulti(db *pg.DB, devices *[]Device) *JSONError {
-	for _, d := range *devices {
-		if err := AddDevice(db, &d); err != nil {
-			return err
+// AddDeviceMulti inserts multiple `Device` into the database in a single
+// transaction. The optional `setup` function is called after each insertion,
+// and any error it returns rolls back the whole transaction.
+func AddDeviceMulti(db *pg.DB, devices *[]Device, setup func(*Device) *JSONError) *JSONError {
+	var jsonErr *JSONError
+
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		for i := range *devices {
+			d := &(*devices)[i]
+			if jsonErr = addDevice(tx, d); jsonErr != nil {
+				return errors.New(jsonErr.Error)
+			}
+
+			if setup == nil {
+				continue
+			}
+			if jsonErr = setup(d); jsonErr != nil {
+				return errors.New(jsonErr.Error)
+			}
 		}
+		return nil
+	})
+
+	if err != nil && jsonErr == nil {
+		return NewInternalServerError()
 	}
-	return nil
+
+	return jsonErr
 }
 
 // GetDevice returns the `Device` with the given `deviceID` from the database
@@ -55,6 +216,17 @@ func GetDevice(db *pg.DB, deviceID int64) (*Device, *JSONError) {
 	return device, nil
 }
 
+// GetDevicesByOwner returns the `Device` owned by the `User` with the given
+// `uid`
+func GetDevicesByOwner(db *pg.DB, uid int64) (*[]Device, *JSONError) {
+	devices := &[]Device{}
+	if err := db.Model(devices).Where("owner = ?", uid).Order("id").Select(); err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	return devices, nil
+}
+
 // GetAllDevices returns all the `Device` from the database
 func GetAllDevices(db *pg.DB) (*[]Device, *JSONError) {
 	devices := &[]Device{}
@@ -65,6 +237,41 @@ func GetAllDevices(db *pg.DB) (*[]Device, *JSONError) {
 	return devices, nil
 }
 
+// GetDevicesByProvider returns all the `Device` plugged into the provider
+// with the given `name`
+func GetDevicesByProvider(db *pg.DB, name string) (*[]Device, *JSONError) {
+	devices := &[]Device{}
+	if err := db.Model(devices).Where("provider = ?", name).Select(); err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	return devices, nil
+}
+
+// UpdateDeviceState saves the state reported by the provider (i.e. the
+// `Hostname`, `PowerState` and `IsMissing` fields) of the given `Device`,
+// unless its power state changed in the database since `changedAt`, in which
+// case the device is left untouched and false is returned. It fails with a
+// Conflict if another device already has the hostname.
+func UpdateDeviceState(db *pg.DB, device *Device, changedAt time.Time) (bool, *JSONError) {
+	res, err := db.Model(device).
+		Column("hostname", "power_state", "power_state_changed_at", "is_missing").
+		WherePK().
+		Where("power_state_changed_at = ?", changedAt).
+		Update()
+	if err != nil {
+		if pgErr, ok := err.(pg.Error); ok && pgErr.IntegrityViolation() {
+			return false, &JSONError{
+				Status: http.StatusConflict,
+				Error:  "a device already has this hostname.",
+			}
+		}
+		return false, NewInternalServerError()
+	}
+
+	return res.RowsAffected() > 0, nil
+}
+
 // DeleteDevice removes the given Rubus `Device` from the database
 func DeleteDevice(db *pg.DB, uid int64) *JSONError {
 	device := &Device{ID: uid}
@@ -81,37 +288,353 @@ func DeleteDevice(db *pg.DB, uid int64) *JSONError {
 	return nil
 }
 
-// SwitchDevicePower inverse the `isTurnedOn` field of the `Device` with the given
-// `deviceID`
-func SwitchDevicePower(db *pg.DB, device *Device) (*Device, *JSONError) {
-	device.IsTurnedOn = !device.IsTurnedOn
+// DiscoveredDevice is a device reported by a provider, along with the id of
+// the matching `Device` if it is already registered
+type DiscoveredDevice struct {
+	Provider   string     `json:"provider" example:"default"`
+	Port       int64      `json:"port" example:"11"`
+	Hostname   string     `json:"hostname" example:"chasseral-1"`
+	PowerState PowerState `json:"powerState" example:"on"`
+	Registered bool       `json:"registered"`
+	DeviceID   *int64     `json:"deviceId"`
+}
 
-	if err := db.Update(device); err != nil {
-		return nil, NewInternalServerError()
+// ImportDevices is the model sent to import devices discovered on a provider
+type ImportDevices struct {
+	Provider string  `json:"provider" example:"default"`
+	Ports    []int64 `json:"ports" example:"1,2,3"`
+}
+
+// UpdateDeviceLocation saves the `Provider`, `Port` and `Address` of the
+// given `Device`
+func UpdateDeviceLocation(db *pg.DB, device *Device) *JSONError {
+	_, err := db.Model(device).Column("provider", "port", "address").WherePK().Update()
+	if err != nil {
+		if pgErr, ok := err.(pg.Error); ok && pgErr.IntegrityViolation() {
+			return &JSONError{
+				Status: http.StatusConflict,
+				Error:  "a device is already plugged on this provider port.",
+			}
+		}
+		return NewInternalServerError()
 	}
 
-	return device, nil
+	return nil
 }
 
-// AcquireDevice sets the `User` parameter as the owner of the `Device`
-func AcquireDevice(db *pg.DB, device *Device, uid int64) *JSONError {
-	device.Owner = &uid
+// UpdateDeviceLabels saves the `Tags`, `Model` and `Pool` of the given
+// `Device`
+func UpdateDeviceLabels(db *pg.DB, device *Device) *JSONError {
+	if _, err := db.Model(device).Column("tags", "model", "pool").WherePK().Update(); err != nil {
+		if pgErr, ok := err.(pg.Error); ok && pgErr.IntegrityViolation() {
+			return &JSONError{
+				Status: http.StatusNotFound,
+				Error:  "pool does not exist.",
+			}
+		}
+		return NewInternalServerError()
+	}
 
-	if err := db.Update(device); err != nil {
+	return nil
+}
+
+// SetDevicePower sets the `PowerState` of the given `Device` and saves it
+// along with the time of the last transition
+func SetDevicePower(db *pg.DB, device *Device, state PowerState) *JSONError {
+	device.SetPowerState(state)
+
+	_, err := db.Model(device).
+		Column("power_state", "power_state_changed_at").
+		WherePK().
+		Update()
+	if err != nil {
 		return NewInternalServerError()
 	}
 
 	return nil
 }
 
-// ReleaseDevice sets the `owner` of the `Device` as nil, if it is the current
-// `owner` which is modifying it.
-func ReleaseDevice(db *pg.DB, device *Device) *JSONError {
-	device.Owner = nil
+// SetDeviceImage saves the `Image` deployed on the given `Device`, nil
+// meaning the default one
+func SetDeviceImage(db *pg.DB, device *Device, imageID *int64) *JSONError {
+	device.ImageID = imageID
 
-	if err := db.Update(device); err != nil {
+	if _, err := db.Model(device).Column("image_id").WherePK().Update(); err != nil {
 		return NewInternalServerError()
 	}
 
 	return nil
 }
+
+// errOwnerChanged is returned when the owner of a `Device` changed since it
+// was read
+var errOwnerChanged = errors.New("device owner changed")
+
+// saveOwner saves the `Owner` and `LeaseExpiresAt` of the given `Device`,
+// provided that it is still owned by `previous` (nil meaning free). The
+// conditional update waits for any concurrent transaction on the same row,
+// so that only one of them succeeds.
+func saveOwner(db orm.DB, device *Device, previous *int64) error {
+	res, err := db.Model(device).
+		Column("owner", "lease_expires_at").
+		WherePK().
+		Where("owner IS NOT DISTINCT FROM ?", previous).
+		Update()
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() == 0 {
+		return errOwnerChanged
+	}
+	return nil
+}
+
+// ownerChangedOr returns a Conflict `JSONError` if `err` is
+// `errOwnerChanged`, an Internal Server Error otherwise
+func ownerChangedOr(err error) *JSONError {
+	if err == errOwnerChanged {
+		return &JSONError{
+			Status: http.StatusConflict,
+			Error:  "device has been acquired or released in the meantime.",
+		}
+	}
+	return NewInternalServerError()
+}
+
+// AcquireDevice sets the `User` parameter as the owner of the `Device`, for a
+// `Lease` which expires after `duration`. The device must neither be booked
+// by another user during the lease, nor awaited by another user in the queue.
+// If `roleQuota` is not nil, the acquisition must not exceed the `Quota` of
+// the user, given the quota of its role. It fails with a Conflict if the user
+// already owns the device, or if the owner of the device changed since it was
+// read.
+func AcquireDevice(db *pg.DB, device *Device, uid int64, duration time.Duration, roleQuota *Quota) *JSONError {
+	return acquireDevice(db, device, uid, duration, roleQuota, EnumLeaseReleased, "", true, true)
+}
+
+// GrantDevice sets the `User` parameter as the owner of the `Device` like
+// `AcquireDevice` does, without giving precedence to the other users waiting
+// in the queue. It grants the devices to the queue and reservations in turn.
+func GrantDevice(db *pg.DB, device *Device, uid int64, duration time.Duration, roleQuota *Quota) *JSONError {
+	return acquireDevice(db, device, uid, duration, roleQuota, EnumLeaseReleased, "", true, false)
+}
+
+// TransferDevice sets the `User` parameter as the owner of the `Device`, for
+// a `Lease` which expires after `duration`, within the user's `Quota` if
+// `roleQuota` is not nil. The lease of the previous owner ends as
+// transferred, explained by the given `note`. It fails with a Conflict if
+// the owner of the device changed since it was read.
+func TransferDevice(db *pg.DB, device *Device, uid int64, duration time.Duration, roleQuota *Quota, note string) *JSONError {
+	return acquireDevice(db, device, uid, duration, roleQuota, EnumLeaseTransferred, note, false, false)
+}
+
+// acquireDevice ends the active `Lease` of the `Device`, if any, for the
+// given `reason`, and starts a new one for the `User` parameter, within its
+// quota if `roleQuota` is not nil. A user cannot acquire a device it already
+// owns, since a new lease would reset its renewals: the lease must be renewed
+// instead. The device must not be booked by another user during the lease if
+// `booked` is true, nor awaited by another user in the queue if `queued` is
+// true. It is locked while it is checked, so that it cannot be booked in the
+// meantime.
+func acquireDevice(db *pg.DB, device *Device, uid int64, duration time.Duration, roleQuota *Quota, reason LeaseEndReason, note string, booked, queued bool) *JSONError {
+	previous := device.Owner
+	expiresAt := device.LeaseExpiresAt
+
+	if previous != nil && *previous == uid {
+		return &JSONError{
+			Status: http.StatusConflict,
+			Error:  "device is already owned by this user.",
+		}
+	}
+
+	var jsonErr *JSONError
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		// the user is locked before the device, like the other acquisitions do
+		if roleQuota != nil {
+			if jsonErr = checkQuota(tx, uid, *roleQuota, 1, duration); jsonErr != nil {
+				return errors.New(jsonErr.Error)
+			}
+		}
+
+		locked := &Device{ID: device.ID}
+		if err := tx.Model(locked).WherePK().For("UPDATE").Select(); err != nil {
+			return err
+		}
+
+		if booked {
+			now := time.Now()
+			if jsonErr = checkReservations(tx, []int64{device.ID}, now, now.Add(duration), uid); jsonErr != nil {
+				return errors.New(jsonErr.Error)
+			}
+		}
+
+		// the users waiting in the queue are served first
+		if queued {
+			if jsonErr = checkQueue(tx, locked, uid); jsonErr != nil {
+				return errors.New(jsonErr.Error)
+			}
+		}
+
+		if err := endLease(tx, device.ID, reason, note); err != nil {
+			return err
+		}
+
+		device.Owner = &uid
+		if _, err := startLease(tx, device, duration); err != nil {
+			return err
+		}
+
+		return saveOwner(tx, device, previous)
+	})
+	if err != nil {
+		device.Owner = previous
+		device.LeaseExpiresAt = expiresAt
+		if jsonErr != nil {
+			return jsonErr
+		}
+		if err == pg.ErrNoRows {
+			return &JSONError{
+				Status: http.StatusNotFound,
+				Error:  "device does not exist.",
+			}
+		}
+		return ownerChangedOr(err)
+	}
+
+	return nil
+}
+
+// AcquireDevices sets the `User` parameter as the owner of `Count` free
+// devices matching the given `DeviceCriteria`, for a `Lease` which expires
+// after `duration`. A free device is neither owned, missing, booked by another
+// user during the lease, nor awaited by another user in the queue. Either
+// every device is acquired, or none. If `roleQuota` is not nil, the
+// acquisition must not exceed the `Quota` of the user, given the quota of its
+// role.
+func AcquireDevices(db *pg.DB, criteria *DeviceCriteria, uid int64, duration time.Duration, roleQuota *Quota) (*[]Device, *JSONError) {
+	devices := &[]Device{}
+	var jsonErr *JSONError
+
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		if roleQuota != nil {
+			leased := time.Duration(criteria.Count) * duration
+			if jsonErr = checkQuota(tx, uid, *roleQuota, criteria.Count, leased); jsonErr != nil {
+				return errors.New(jsonErr.Error)
+			}
+		}
+
+		now := time.Now()
+
+		// the rows locked by a concurrent acquisition are skipped, since
+		// they are about to be owned
+		query := tx.Model(devices).
+			Where("owner IS NULL").
+			Where("is_missing = false").
+			Where(`NOT EXISTS (SELECT 1 FROM reservations AS r
+				WHERE device.id = ANY(r.device_ids) AND r.user_id <> ? AND r.status IN (?)
+				AND r.starts_at < ? AND r.ends_at > ?)`,
+				uid, pg.In([]ReservationStatus{EnumReservationPending, EnumReservationActive}),
+				now.Add(duration), now).
+			Where("NOT "+awaitedByOthers, EnumQueueWaiting, uid).
+			Order("id").
+			Limit(criteria.Count).
+			For("UPDATE SKIP LOCKED")
+
+		if len(criteria.Tags) > 0 {
+			query = query.Where("tags @> ?", pg.Array(criteria.Tags))
+		}
+		if criteria.Image != nil {
+			query = query.Where("image_id = ?", *criteria.Image)
+		}
+		if criteria.Model != "" {
+			query = query.Where("model = ?", criteria.Model)
+		}
+		if criteria.Pool != "" {
+			query = query.Where("pool = ?", criteria.Pool)
+		}
+		if len(criteria.IDs) > 0 {
+			query = query.Where("id IN (?)", pg.In(criteria.IDs))
+		}
+
+		if err := query.Select(); err != nil {
+			return err
+		}
+
+		if len(*devices) < criteria.Count {
+			jsonErr = &JSONError{
+				Status: http.StatusConflict,
+				Error: fmt.Sprintf("insufficient capacity: %d devices requested, %d available.",
+					criteria.Count, len(*devices)),
+			}
+			return errors.New(jsonErr.Error)
+		}
+
+		for i := range *devices {
+			device := &(*devices)[i]
+			device.Owner = &uid
+			if _, err := startLease(tx, device, duration); err != nil {
+				return err
+			}
+			if _, err := tx.Model(device).Column("owner", "lease_expires_at").WherePK().Update(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		if jsonErr == nil {
+			jsonErr = NewInternalServerError()
+		}
+		return nil, jsonErr
+	}
+
+	return devices, nil
+}
+
+// ReleaseDevice sets the `owner` of the `Device` as nil and ends its `Lease`
+// for the given `reason`. It fails with a Conflict if the owner of the device
+// changed since it was read.
+func ReleaseDevice(db *pg.DB, device *Device, reason LeaseEndReason) *JSONError {
+	return releaseDevice(db, device, reason, "")
+}
+
+// RevokeDevice sets the `owner` of the `Device` as nil and ends its `Lease`
+// as revoked, explained by the given `note`. It fails with a Conflict if the
+// owner of the device changed since it was read.
+func RevokeDevice(db *pg.DB, device *Device, note string) *JSONError {
+	return releaseDevice(db, device, EnumLeaseRevoked, note)
+}
+
+// EndDeviceLease sets the `owner` of the `Device` as nil and ends its `Lease`
+// for the given `reason`, explained by the optional `note`. It fails with a
+// Conflict if the owner of the device changed since it was read.
+func EndDeviceLease(db *pg.DB, device *Device, reason LeaseEndReason, note string) *JSONError {
+	return releaseDevice(db, device, reason, note)
+}
+
+// releaseDevice sets the `owner` of the `Device` as nil and ends its `Lease`
+// for the given `reason`, explained by the optional `note`
+func releaseDevice(db *pg.DB, device *Device, reason LeaseEndReason, note string) *JSONError {
+	previous := device.Owner
+	expiresAt := device.LeaseExpiresAt
+
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		device.Owner = nil
+		device.LeaseExpiresAt = nil
+		if err := saveOwner(tx, device, previous); err != nil {
+			return err
+		}
+
+		return endLease(tx, device.ID, reason, note)
+	})
+	if err != nil {
+		device.Owner = previous
+		device.LeaseExpiresAt = expiresAt
+		return ownerChangedOr(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// Image is an operating system image which can be deployed on a `Device`. It
+// is used as the read-only lower layer of the device's overlay.
+type Image struct {
+	ID           int64     `json:"id" pg:",pk" example:"1"`
+	Name         string    `json:"name" pg:",notnull,unique:name_version" example:"raspios-lite"`
+	Version      string    `json:"version" pg:",notnull,unique:name_version" example:"2020-05-27"`
+	Architecture string    `json:"architecture" example:"armhf"`
+	Checksum     string    `json:"checksum" example:"sha256:f5786604be4b41e292c5b3c711e2efa64b25a5b51869ea8313d58da0b46afc64"`
+	Description  string    `json:"description" example:"Raspberry Pi OS Lite (32-bit)"`
+	Path         string    `json:"path" pg:",notnull" example:"/pxe/nfs/raspios-lite-2020-05-27"`
+	Retired      bool      `json:"retired" pg:",use_zero"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// NewImage is the model sent to register a new `Image`
+type NewImage struct {
+	Name         string `json:"name" example:"raspios-lite"`
+	Version      string `json:"version" example:"2020-05-27"`
+	Architecture string `json:"architecture" example:"armhf"`
+	Checksum     string `json:"checksum" example:"sha256:f5786604be4b41e292c5b3c711e2efa64b25a5b51869ea8313d58da0b46afc64"`
+	Description  string `json:"description" example:"Raspberry Pi OS Lite (32-bit)"`
+	Path         string `json:"path" example:"/pxe/nfs/raspios-lite-2020-05-27"`
+}
+
+// AddImage inserts a new `Image` into the database
+func AddImage(db *pg.DB, image *Image) *JSONError {
+	image.CreatedAt = time.Now()
+
+	if err := db.Insert(image); err != nil {
+		if pgErr, ok := err.(pg.Error); ok && pgErr.IntegrityViolation() {
+			return &JSONError{
+				Status: http.StatusConflict,
+				Error:  "image name and version already exist.",
+			}
+		}
+		return NewInternalServerError()
+	}
+
+	return nil
+}
+
+// GetImage returns the `Image` with the given `imageID` from the database
+func GetImage(db *pg.DB, imageID int64) (*Image, *JSONError) {
+	image := &Image{ID: imageID}
+	if err := db.Select(image); err != nil {
+		if err == pg.ErrNoRows {
+			return nil, &JSONError{
+				Status: http.StatusNotFound,
+				Error:  "image does not exist.",
+			}
+		}
+		return nil, NewInternalServerError()
+	}
+
+	return image, nil
+}
+
+// GetAllImages returns all the `Image` from the database. The retired images
+// are only returned if `withRetired` is true.
+func GetAllImages(db *pg.DB, withRetired bool) (*[]Image, *JSONError) {
+	images := &[]Image{}
+	query := db.Model(images).Order("name", "version")
+	if !withRetired {
+		query = query.Where("retired = false")
+	}
+
+	if err := query.Select(); err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	return images, nil
+}
+
+// RetireImage marks the `Image` with the given `imageID` as retired, so that
+// it cannot be deployed anymore. Devices already running it are not affected.
+func RetireImage(db *pg.DB, imageID int64) (*Image, *JSONError) {
+	image, jsonErr := GetImage(db, imageID)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	image.Retired = true
+	if _, err := db.Model(image).Column("retired").WherePK().Update(); err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	return image, nil
+}
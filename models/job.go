@@ -0,0 +1,139 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// JobType is an enum which specify the operation performed by a `Job`
+type JobType string
+
+// Values for `JobType` enum
+const (
+	EnumJobCreateDevice JobType = "create-device"
+	EnumJobDeleteDevice JobType = "delete-device"
+	EnumJobDeploy       JobType = "deploy"
+	EnumJobWipe         JobType = "wipe"
+	EnumJobSnapshot     JobType = "snapshot"
+	EnumJobRestore      JobType = "restore"
+	EnumJobPowerOn      JobType = "power-on"
+	EnumJobPowerOff     JobType = "power-off"
+	EnumJobReboot       JobType = "reboot"
+)
+
+// JobStatus is an enum which specify the status of a `Job`
+type JobStatus string
+
+// Values for `JobStatus` enum
+const (
+	EnumJobPending   JobStatus = "pending"
+	EnumJobRunning   JobStatus = "running"
+	EnumJobSucceeded JobStatus = "succeeded"
+	EnumJobFailed    JobStatus = "failed"
+	EnumJobCanceled  JobStatus = "canceled"
+)
+
+// Job is a long-running operation performed in the background on a `Device`,
+// possibly as part of a `Batch`
+type Job struct {
+	ID          int64     `json:"id" pg:",pk" example:"1"`
+	Type        JobType   `json:"type" pg:",notnull" example:"deploy"`
+	DeviceID    *int64    `json:"deviceId" example:"1"`
+	BatchID     *int64    `json:"batchId" example:"1"`
+	RequesterID int64     `json:"requesterId" example:"1"`
+	Status      JobStatus `json:"status" pg:",notnull" example:"succeeded"`
+	CreatedAt   time.Time `json:"createdAt"`
+	StartedAt   time.Time `json:"startedAt"`
+	EndedAt     time.Time `json:"endedAt"`
+	Output      string    `json:"output"`
+	ExitCode    *int      `json:"exitCode" example:"0"`
+	Error       string    `json:"error"`
+}
+
+// IsDone returns true if the `Job` will not run anymore
+func (j *Job) IsDone() bool {
+	return j.Status == EnumJobSucceeded ||
+		j.Status == EnumJobFailed ||
+		j.Status == EnumJobCanceled
+}
+
+// AddJob inserts a new `Job` into the database
+func AddJob(db *pg.DB, job *Job) *JSONError {
+	if err := db.Insert(job); err != nil {
+		return NewInternalServerError()
+	}
+
+	return nil
+}
+
+// GetJob returns the `Job` with the given `jobID` from the database
+func GetJob(db *pg.DB, jobID int64) (*Job, *JSONError) {
+	job := &Job{ID: jobID}
+	if err := db.Select(job); err != nil {
+		if err == pg.ErrNoRows {
+			return nil, &JSONError{
+				Status: http.StatusNotFound,
+				Error:  "job does not exist.",
+			}
+		}
+		return nil, NewInternalServerError()
+	}
+
+	return job, nil
+}
+
+// GetAllJobs returns all the `Job` from the database, most recent first. If
+// `requesterID` is not nil, only the jobs requested by this `User` are
+// returned.
+func GetAllJobs(db *pg.DB, requesterID *int64) (*[]Job, *JSONError) {
+	jobs := &[]Job{}
+	query := db.Model(jobs).Order("id DESC")
+	if requesterID != nil {
+		query = query.Where("requester_id = ?", *requesterID)
+	}
+
+	if err := query.Select(); err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	return jobs, nil
+}
+
+// GetJobsByDevice returns all the `Job` performed on the `Device` with the
+// given `deviceID`, most recent first
+func GetJobsByDevice(db *pg.DB, deviceID int64) (*[]Job, *JSONError) {
+	jobs := &[]Job{}
+	if err := db.Model(jobs).Where("device_id = ?", deviceID).Order("id DESC").Select(); err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	return jobs, nil
+}
+
+// UpdateJob saves the given `Job`
+func UpdateJob(db *pg.DB, job *Job) *JSONError {
+	if err := db.Update(job); err != nil {
+		return NewInternalServerError()
+	}
+
+	return nil
+}
+
+// FailInterruptedJobs marks the jobs which were still pending or running as
+// failed. It is meant to be called at startup, since jobs cannot survive a
+// restart of the API.
+func FailInterruptedJobs(db *pg.DB) *JSONError {
+	_, err := db.Model((*Job)(nil)).
+		Set("status = ?", EnumJobFailed).
+		Set("error = ?", "interrupted by a restart of the API.").
+		Set("ended_at = ?", time.Now()).
+		Where("status IN (?)", pg.In([]JobStatus{EnumJobPending, EnumJobRunning})).
+		Update()
+	if err != nil {
+		return NewInternalServerError()
+	}
+
+	return nil
+}
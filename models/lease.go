@@ -0,0 +1,190 @@
+package models
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
+)
+
+// LeaseEndReason is an enum which specify why a `Lease` ended
+type LeaseEndReason string
+
+// Values for `LeaseEndReason` enum
+const (
+	EnumLeaseReleased    LeaseEndReason = "released"
+	EnumLeaseExpired     LeaseEndReason = "expired"
+	EnumLeaseRevoked     LeaseEndReason = "revoked"
+	EnumLeaseTransferred LeaseEndReason = "transferred"
+)
+
+// Lease is the time-bounded ownership of a `Device` by a `User`. A lease is
+// active until it ends, either because the device has been released or
+// because the lease expired.
+type Lease struct {
+	ID        int64          `json:"id" pg:",pk" example:"1"`
+	DeviceID  int64          `json:"deviceId" pg:",notnull" example:"1"`
+	UserID    int64          `json:"userId" pg:",notnull" example:"1"`
+	StartedAt time.Time      `json:"startedAt"`
+	ExpiresAt time.Time      `json:"expiresAt"`
+	Renewals  int            `json:"renewals" pg:",use_zero" example:"0"`
+	EndedAt   time.Time      `json:"endedAt"`
+	EndReason LeaseEndReason `json:"endReason" example:"released"`
+	EndNote   string         `json:"endNote" example:"device is stuck."`
+}
+
+// IsActive returns true if the `Lease` has not ended yet
+func (l *Lease) IsActive() bool {
+	return l.EndedAt.IsZero()
+}
+
+// startLease inserts the active `Lease` of the given `Device`, which expires
+// after `duration`
+func startLease(db orm.DB, device *Device, duration time.Duration) (*Lease, error) {
+	now := time.Now()
+	lease := &Lease{
+		DeviceID:  device.ID,
+		UserID:    *device.Owner,
+		StartedAt: now,
+		ExpiresAt: now.Add(duration),
+	}
+
+	if err := db.Insert(lease); err != nil {
+		return nil, err
+	}
+
+	device.LeaseExpiresAt = &lease.ExpiresAt
+	return lease, nil
+}
+
+// endLease ends the active `Lease` of the `Device` with the given `deviceID`,
+// if any, for the given `reason`, explained by the optional `note`
+func endLease(db orm.DB, deviceID int64, reason LeaseEndReason, note string) error {
+	_, err := db.Model((*Lease)(nil)).
+		Set("ended_at = ?", time.Now()).
+		Set("end_reason = ?", reason).
+		Set("end_note = ?", note).
+		Where("device_id = ?", deviceID).
+		Where("ended_at IS NULL").
+		Update()
+	return err
+}
+
+// GetActiveLease returns the active `Lease` of the `Device` with the given
+// `deviceID` from the database
+func GetActiveLease(db *pg.DB, deviceID int64) (*Lease, *JSONError) {
+	lease := &Lease{}
+	err := db.Model(lease).Where("device_id = ?", deviceID).Where("ended_at IS NULL").Select()
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, &JSONError{
+				Status: http.StatusConflict,
+				Error:  "device is not leased.",
+			}
+		}
+		return nil, NewInternalServerError()
+	}
+
+	return lease, nil
+}
+
+// GetExpiredLeases returns the active `Lease` which have expired
+func GetExpiredLeases(db *pg.DB) (*[]Lease, *JSONError) {
+	leases := &[]Lease{}
+	err := db.Model(leases).
+		Where("ended_at IS NULL").
+		Where("expires_at < ?", time.Now()).
+		Order("expires_at").
+		Select()
+	if err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	return leases, nil
+}
+
+// RenewLease extends the active `Lease` of the given `Device` so that it
+// expires `duration` from now. At most `maxRenewals` renewals are allowed,
+// 0 meaning unlimited. If `roleQuota` is not nil, the time added to the lease
+// must not exceed the lease hours of the owner's `Quota`, given the quota of
+// its role. The lease is locked while it is renewed, so that concurrent
+// renewals are counted, and it fails with a Conflict if the owner of the
+// device changed since it was read.
+func RenewLease(db *pg.DB, device *Device, duration time.Duration, maxRenewals int, roleQuota *Quota) (*Lease, *JSONError) {
+	lease := &Lease{}
+	expiresAt := device.LeaseExpiresAt
+
+	if device.Owner == nil {
+		return nil, &JSONError{
+			Status: http.StatusConflict,
+			Error:  "device is not leased.",
+		}
+	}
+
+	var jsonErr *JSONError
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		// the owner is locked before the lease, like the acquisitions do
+		var quota Quota
+		if roleQuota != nil {
+			if quota, jsonErr = lockUserQuota(tx, *device.Owner, *roleQuota); jsonErr != nil {
+				return errors.New(jsonErr.Error)
+			}
+		}
+
+		err := tx.Model(lease).
+			Where("device_id = ?", device.ID).
+			Where("ended_at IS NULL").
+			For("UPDATE").
+			Select()
+		if err != nil {
+			return err
+		}
+
+		if lease.UserID != *device.Owner {
+			return errOwnerChanged
+		}
+
+		if maxRenewals > 0 && lease.Renewals >= maxRenewals {
+			jsonErr = &JSONError{
+				Status: http.StatusConflict,
+				Error:  "lease cannot be renewed anymore.",
+			}
+			return errors.New(jsonErr.Error)
+		}
+
+		// only the time added to the lease counts against the lease hours
+		now := time.Now()
+		if roleQuota != nil {
+			extension := now.Add(duration).Sub(lease.ExpiresAt)
+			if jsonErr = checkQuotaUsage(tx, lease.UserID, quota, 0, extension); jsonErr != nil {
+				return errors.New(jsonErr.Error)
+			}
+		}
+
+		lease.ExpiresAt = now.Add(duration)
+		lease.Renewals++
+		if _, err := tx.Model(lease).Column("expires_at", "renewals").WherePK().Update(); err != nil {
+			return err
+		}
+
+		device.LeaseExpiresAt = &lease.ExpiresAt
+		return saveOwner(tx, device, device.Owner)
+	})
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+	if err != nil {
+		device.LeaseExpiresAt = expiresAt
+		if err == pg.ErrNoRows {
+			return nil, &JSONError{
+				Status: http.StatusConflict,
+				Error:  "device is not leased.",
+			}
+		}
+		return nil, ownerChangedOr(err)
+	}
+
+	return lease, nil
+}
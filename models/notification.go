@@ -0,0 +1,77 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// NotificationType is an enum which specify the event a `Notification` is
+// about
+type NotificationType string
+
+// Values for `NotificationType` enum
+const (
+	EnumNotificationDeviceGranted NotificationType = "device-granted"
+	EnumNotificationDeviceRevoked NotificationType = "device-revoked"
+)
+
+// Notification is an event sent to a `User`
+type Notification struct {
+	ID        int64            `json:"id" pg:",pk" example:"1"`
+	UserID    int64            `json:"userId" pg:",notnull" example:"1"`
+	Type      NotificationType `json:"type" pg:",notnull" example:"device-granted"`
+	DeviceID  *int64           `json:"deviceId" example:"1"`
+	Message   string           `json:"message" example:"device 1 has been granted to you."`
+	CreatedAt time.Time        `json:"createdAt"`
+}
+
+// AddNotification inserts a new `Notification` into the database
+func AddNotification(db *pg.DB, notification *Notification) *JSONError {
+	notification.CreatedAt = time.Now()
+
+	if err := db.Insert(notification); err != nil {
+		return NewInternalServerError()
+	}
+
+	return nil
+}
+
+// GetNotificationsByUser returns the `Notification` of the `User` with the
+// given `userID`, most recent first. Only the notifications whose id is
+// greater than `since` are returned.
+func GetNotificationsByUser(db *pg.DB, userID, since int64) (*[]Notification, *JSONError) {
+	notifications := &[]Notification{}
+	err := db.Model(notifications).
+		Where("user_id = ?", userID).
+		Where("id > ?", since).
+		Order("id DESC").
+		Select()
+	if err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	return notifications, nil
+}
+
+// DeleteNotification removes the `Notification` with the given
+// `notificationID` of the `User` with the given `userID` from the database
+func DeleteNotification(db *pg.DB, userID, notificationID int64) *JSONError {
+	res, err := db.Model((*Notification)(nil)).
+		Where("id = ?", notificationID).
+		Where("user_id = ?", userID).
+		Delete()
+	if err != nil {
+		return NewInternalServerError()
+	}
+
+	if res.RowsAffected() == 0 {
+		return &JSONError{
+			Status: http.StatusNotFound,
+			Error:  "notification does not exist.",
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// DevicePool is a named group of `Device`, such as a rack used for a course.
+// A device belongs to at most one pool, given by its `Pool`.
+type DevicePool struct {
+	Name        string    `json:"name" pg:",pk" example:"rack-1"`
+	Description string    `json:"description" example:"Devices of the networking course"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Devices     int       `json:"devices" pg:"-" example:"8"`
+}
+
+// NewDevicePool is the model sent to create a `DevicePool`
+type NewDevicePool struct {
+	Name        string `json:"name" example:"rack-1"`
+	Description string `json:"description" example:"Devices of the networking course"`
+}
+
+// PoolMembers is the model sent to add devices to a `DevicePool`
+type PoolMembers struct {
+	Devices []int64 `json:"devices" example:"1,2,3"`
+}
+
+// AddDevicePool inserts a new `DevicePool` into the database
+func AddDevicePool(db *pg.DB, pool *DevicePool) *JSONError {
+	pool.CreatedAt = time.Now()
+
+	if err := db.Insert(pool); err != nil {
+		if pgErr, ok := err.(pg.Error); ok && pgErr.IntegrityViolation() {
+			return &JSONError{
+				Status: http.StatusConflict,
+				Error:  "pool already exists.",
+			}
+		}
+		return NewInternalServerError()
+	}
+
+	return nil
+}
+
+// GetDevicePool returns the `DevicePool` with the given `name` from the
+// database, along with its number of devices
+func GetDevicePool(db *pg.DB, name string) (*DevicePool, *JSONError) {
+	pool := &DevicePool{Name: name}
+	if err := db.Select(pool); err != nil {
+		if err == pg.ErrNoRows {
+			return nil, &JSONError{
+				Status: http.StatusNotFound,
+				Error:  "pool does not exist.",
+			}
+		}
+		return nil, NewInternalServerError()
+	}
+
+	count, err := db.Model((*Device)(nil)).Where("pool = ?", name).Count()
+	if err != nil {
+		return nil, NewInternalServerError()
+	}
+	pool.Devices = count
+
+	return pool, nil
+}
+
+// GetAllDevicePools returns all the `DevicePool` from the database, by name,
+// along with their number of devices
+func GetAllDevicePools(db *pg.DB) (*[]DevicePool, *JSONError) {
+	pools := &[]DevicePool{}
+	if err := db.Model(pools).Order("name").Select(); err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	var counts []struct {
+		Pool  string
+		Count int
+	}
+	err := db.Model((*Device)(nil)).
+		Column("pool").
+		ColumnExpr("count(*) AS count").
+		Where("pool IS NOT NULL").
+		Group("pool").
+		Select(&counts)
+	if err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	for _, count := range counts {
+		for i := range *pools {
+			if (*pools)[i].Name == count.Pool {
+				(*pools)[i].Devices = count.Count
+			}
+		}
+	}
+
+	return pools, nil
+}
+
+// DeleteDevicePool removes the `DevicePool` with the given `name` from the
+// database. Its devices do not belong to any pool anymore.
+func DeleteDevicePool(db *pg.DB, name string) *JSONError {
+	res, err := db.Model(&DevicePool{Name: name}).WherePK().Delete()
+	if err != nil {
+		return NewInternalServerError()
+	}
+
+	if res.RowsAffected() == 0 {
+		return &JSONError{
+			Status: http.StatusNotFound,
+			Error:  "pool does not exist.",
+		}
+	}
+
+	return nil
+}
+
+// GetPoolDevices returns the `Device` of the `DevicePool` with the given
+// `name`, by id
+func GetPoolDevices(db *pg.DB, name string) (*[]Device, *JSONError) {
+	devices := &[]Device{}
+	if err := db.Model(devices).Where("pool = ?", name).Order("id").Select(); err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	return devices, nil
+}
+
+// AddPoolDevices moves the devices with the given `deviceIDs` into the
+// `DevicePool` with the given `name`. Either every device is moved, or none.
+func AddPoolDevices(db *pg.DB, name string, deviceIDs []int64) *JSONError {
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		res, err := tx.Model((*Device)(nil)).
+			Set("pool = ?", name).
+			Where("id IN (?)", pg.In(deviceIDs)).
+			Update()
+		if err != nil {
+			return err
+		}
+
+		if res.RowsAffected() != len(deviceIDs) {
+			return pg.ErrNoRows
+		}
+		return nil
+	})
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return &JSONError{
+				Status: http.StatusNotFound,
+				Error:  "device does not exist.",
+			}
+		}
+		return NewInternalServerError()
+	}
+
+	return nil
+}
+
+// RemovePoolDevice removes the `Device` with the given `deviceID` from the
+// `DevicePool` with the given `name`
+func RemovePoolDevice(db *pg.DB, name string, deviceID int64) *JSONError {
+	res, err := db.Model((*Device)(nil)).
+		Set("pool = NULL").
+		Where("id = ?", deviceID).
+		Where("pool = ?", name).
+		Update()
+	if err != nil {
+		return NewInternalServerError()
+	}
+
+	if res.RowsAffected() == 0 {
+		return &JSONError{
+			Status: http.StatusNotFound,
+			Error:  "device is not in the pool.",
+		}
+	}
+
+	return nil
+}
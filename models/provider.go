@@ -0,0 +1,77 @@
+package models
+
+import (
+	"net/http"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// Provider describes a provider registered by an administrator at runtime.
+// Providers defined in the configuration file are not stored in the database.
+type Provider struct {
+	Name    string `json:"name" pg:",pk" example:"poe-2"`
+	BaseURL string `json:"baseUrl" pg:",notnull" example:"http://rubus_provider_2:1080"`
+	Token   string `json:"-"`
+}
+
+// NewProvider is the model sent to register a new `Provider`
+type NewProvider struct {
+	Name    string `json:"name" example:"poe-2"`
+	BaseURL string `json:"baseUrl" example:"http://rubus_provider_2:1080"`
+	Token   string `json:"token" example:"secret"`
+}
+
+// AddProvider inserts a new `Provider` into the database
+func AddProvider(db *pg.DB, p *Provider) *JSONError {
+	if err := db.Insert(p); err != nil {
+		if pgErr, ok := err.(pg.Error); ok && pgErr.IntegrityViolation() {
+			return &JSONError{
+				Status: http.StatusConflict,
+				Error:  "provider already exists.",
+			}
+		}
+		return NewInternalServerError()
+	}
+
+	return nil
+}
+
+// GetAllProviders returns all the `Provider` from the database
+func GetAllProviders(db *pg.DB) (*[]Provider, *JSONError) {
+	providers := &[]Provider{}
+	if err := db.Model(providers).Select(); err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	return providers, nil
+}
+
+// DeleteProvider removes the `Provider` with the given `name` from the
+// database, as long as no `Device` refers to it
+func DeleteProvider(db *pg.DB, name string) *JSONError {
+	count, err := db.Model((*Device)(nil)).Where("provider = ?", name).Count()
+	if err != nil {
+		return NewInternalServerError()
+	}
+
+	if count > 0 {
+		return &JSONError{
+			Status: http.StatusConflict,
+			Error:  "provider is still used by some devices.",
+		}
+	}
+
+	res, err := db.Model((*Provider)(nil)).Where("name = ?", name).Delete()
+	if err != nil {
+		return NewInternalServerError()
+	}
+
+	if res.RowsAffected() == 0 {
+		return &JSONError{
+			Status: http.StatusNotFound,
+			Error:  "provider does not exist.",
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
+)
+
+// QueueStatus is an enum which specify the status of a `QueueEntry`
+type QueueStatus string
+
+// Values for `QueueStatus` enum
+const (
+	EnumQueueWaiting  QueueStatus = "waiting"
+	EnumQueueGranted  QueueStatus = "granted"
+	EnumQueueCanceled QueueStatus = "canceled"
+)
+
+// QueueEntry is a `User` waiting for a busy `Device`: either the one with the
+// given `DeviceID`, or any device matching the given filters. The entries are
+// granted in FIFO order, as soon as a device they wait for is released.
+type QueueEntry struct {
+	ID        int64       `json:"id" pg:",pk" example:"1"`
+	UserID    int64       `json:"userId" pg:",notnull" example:"1"`
+	DeviceID  *int64      `json:"deviceId" example:"1"`
+	Tags      []string    `json:"tags" pg:",array" example:"camera"`
+	ImageID   *int64      `json:"imageId" example:"1"`
+	Model     string      `json:"model" example:"Raspberry Pi 4 Model B"`
+	Pool      string      `json:"pool" example:"rack-1"`
+	Duration  string      `json:"duration" pg:",notnull" example:"4h"`
+	Status    QueueStatus `json:"status" pg:",notnull" example:"waiting"`
+	CreatedAt time.Time   `json:"createdAt"`
+	GrantedAt time.Time   `json:"grantedAt"`
+	GrantedID *int64      `json:"grantedId" example:"1"`
+	Position  int         `json:"position" pg:"-" example:"2"`
+}
+
+// NewQueueEntry is the model sent to wait for a `Device`. Without `device`,
+// any device matching the given filters is granted.
+type NewQueueEntry struct {
+	Device *int64   `json:"device" example:"1"`
+	Tags   []string `json:"tags" example:"camera"`
+	Image  *int64   `json:"image" example:"1"`
+	Model  string   `json:"model" example:"Raspberry Pi 4 Model B"`
+	Pool   string   `json:"pool" example:"rack-1"`
+}
+
+// Matches returns true if the `Device` can be granted to the `QueueEntry`
+func (q *QueueEntry) Matches(device *Device) bool {
+	if q.DeviceID != nil {
+		return *q.DeviceID == device.ID
+	}
+
+	if q.ImageID != nil && (device.ImageID == nil || *device.ImageID != *q.ImageID) {
+		return false
+	}
+	if q.Model != "" && q.Model != device.Model {
+		return false
+	}
+	if q.Pool != "" && q.Pool != device.Pool {
+		return false
+	}
+
+	tags := map[string]bool{}
+	for _, tag := range device.Tags {
+		tags[tag] = true
+	}
+	for _, tag := range q.Tags {
+		if !tags[tag] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AddQueueEntry inserts a new waiting `QueueEntry` into the database
+func AddQueueEntry(db *pg.DB, entry *QueueEntry) *JSONError {
+	entry.Status = EnumQueueWaiting
+	entry.CreatedAt = time.Now()
+
+	if err := db.Insert(entry); err != nil {
+		return NewInternalServerError()
+	}
+
+	return nil
+}
+
+// GetQueueEntry returns the `QueueEntry` with the given `entryID` from the
+// database, along with its position if it is waiting
+func GetQueueEntry(db *pg.DB, entryID int64) (*QueueEntry, *JSONError) {
+	entry := &QueueEntry{ID: entryID}
+	if err := db.Select(entry); err != nil {
+		if err == pg.ErrNoRows {
+			return nil, &JSONError{
+				Status: http.StatusNotFound,
+				Error:  "queue entry does not exist.",
+			}
+		}
+		return nil, NewInternalServerError()
+	}
+
+	waiting, jsonErr := GetWaitingQueueEntries(db)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+	for _, w := range *waiting {
+		if w.ID == entry.ID {
+			entry.Position = w.Position
+		}
+	}
+
+	return entry, nil
+}
+
+// GetWaitingQueueEntries returns the waiting `QueueEntry` in FIFO order,
+// along with their position. The position of an entry waiting for a given
+// device counts the entries waiting for the same device, while the position
+// of an entry waiting for any device counts the entries waiting for any
+// device.
+func GetWaitingQueueEntries(db *pg.DB) (*[]QueueEntry, *JSONError) {
+	entries := &[]QueueEntry{}
+	if err := db.Model(entries).Where("status = ?", EnumQueueWaiting).Order("id").Select(); err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	positions := map[int64]int{}
+	anyPosition := 0
+	for i := range *entries {
+		entry := &(*entries)[i]
+		if entry.DeviceID != nil {
+			positions[*entry.DeviceID]++
+			entry.Position = positions[*entry.DeviceID]
+		} else {
+			anyPosition++
+			entry.Position = anyPosition
+		}
+	}
+
+	return entries, nil
+}
+
+// GetAllQueueEntries returns the waiting `QueueEntry` along with their
+// position. If `userID` is not nil, only the entries of this `User` are
+// returned.
+func GetAllQueueEntries(db *pg.DB, userID *int64) (*[]QueueEntry, *JSONError) {
+	entries, jsonErr := GetWaitingQueueEntries(db)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	if userID == nil {
+		return entries, nil
+	}
+
+	filtered := []QueueEntry{}
+	for _, entry := range *entries {
+		if entry.UserID == *userID {
+			filtered = append(filtered, entry)
+		}
+	}
+
+	return &filtered, nil
+}
+
+// checkQueue makes sure no other `User` than the one with the given `userID`
+// is waiting in the queue for the `Device`, since the waiting users are
+// granted the devices first
+func checkQueue(db orm.DB, device *Device, userID int64) *JSONError {
+	entries := []QueueEntry{}
+	err := db.Model(&entries).
+		Where("status = ?", EnumQueueWaiting).
+		Where("user_id <> ?", userID).
+		Select()
+	if err != nil {
+		return NewInternalServerError()
+	}
+
+	for _, entry := range entries {
+		if entry.Matches(device) {
+			return &JSONError{
+				Status: http.StatusConflict,
+				Error:  "device is awaited by other users in the queue.",
+			}
+		}
+	}
+
+	return nil
+}
+
+// awaitedByOthers is the SQL condition, on a device aliased `device`, which
+// is true when a `User` other than the given one waits for the device in the
+// queue. It mirrors `QueueEntry.Matches`.
+const awaitedByOthers = `EXISTS (SELECT 1 FROM queue_entries AS q
+	WHERE q.status = ? AND q.user_id <> ?
+	AND (q.device_id = device.id OR (q.device_id IS NULL
+		AND (q.image_id IS NULL OR q.image_id = device.image_id)
+		AND (coalesce(q.model, '') = '' OR q.model = device.model)
+		AND (coalesce(q.pool, '') = '' OR q.pool = device.pool)
+		AND coalesce(device.tags, '{}') @> coalesce(q.tags, '{}'))))`
+
+// GrantQueueEntry marks the `QueueEntry` as granted the `Device` with the
+// given `deviceID`
+func GrantQueueEntry(db *pg.DB, entry *QueueEntry, deviceID int64) *JSONError {
+	entry.Status = EnumQueueGranted
+	entry.GrantedAt = time.Now()
+	entry.GrantedID = &deviceID
+	entry.Position = 0
+
+	_, err := db.Model(entry).Column("status", "granted_at", "granted_id").WherePK().Update()
+	if err != nil {
+		return NewInternalServerError()
+	}
+
+	return nil
+}
+
+// CancelQueueEntry marks the `QueueEntry` as canceled, if it is still waiting
+func CancelQueueEntry(db *pg.DB, entry *QueueEntry) *JSONError {
+	if entry.Status != EnumQueueWaiting {
+		return &JSONError{
+			Status: http.StatusConflict,
+			Error:  "queue entry is not waiting anymore.",
+		}
+	}
+
+	entry.Status = EnumQueueCanceled
+	entry.Position = 0
+
+	if _, err := db.Model(entry).Column("status").WherePK().Update(); err != nil {
+		return NewInternalServerError()
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
+)
+
+// QuotaWindow is the rolling window over which the lease hours of a `User`
+// are counted
+const QuotaWindow = 7 * 24 * time.Hour
+
+// Quota limits the devices a `User` can acquire. A limit of 0 means
+// unlimited.
+type Quota struct {
+	MaxDevices      int `json:"maxDevices" example:"4"`
+	MaxHoursPerWeek int `json:"maxHoursPerWeek" example:"168"`
+}
+
+// PutQuota is the model sent to override the `Quota` of a `User`. A null
+// limit falls back to the quota of the user's role.
+type PutQuota struct {
+	MaxDevices      *int `json:"maxDevices" example:"4"`
+	MaxHoursPerWeek *int `json:"maxHoursPerWeek" example:"168"`
+}
+
+// QuotaUsage is the `Quota` of a `User` along with its usage. The lease
+// hours count every lease over the last 7 days, up to its end or, if it is
+// still active, up to its expiration.
+type QuotaUsage struct {
+	Quota
+	Devices       int     `json:"devices" example:"1"`
+	HoursThisWeek float64 `json:"hoursThisWeek" example:"24"`
+}
+
+// Quota returns the `Quota` of the `User`, which is the given quota of its
+// role with the user's overrides applied
+func (u *User) Quota(roleQuota Quota) Quota {
+	quota := roleQuota
+	if u.MaxDevices != nil {
+		quota.MaxDevices = *u.MaxDevices
+	}
+	if u.MaxHoursPerWeek != nil {
+		quota.MaxHoursPerWeek = *u.MaxHoursPerWeek
+	}
+
+	return quota
+}
+
+// UpdateUserQuota saves the `Quota` overrides of the given `User`
+func UpdateUserQuota(db *pg.DB, user *User) *JSONError {
+	_, err := db.Model(user).Column("max_devices", "max_hours_per_week").WherePK().Update()
+	if err != nil {
+		return NewInternalServerError()
+	}
+
+	return nil
+}
+
+// GetQuotaUsage returns the usage of the given `Quota` by the `User` with
+// the given `uid`
+func GetQuotaUsage(db *pg.DB, uid int64, quota Quota) (*QuotaUsage, *JSONError) {
+	usage, err := getQuotaUsage(db, uid, quota)
+	if err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	return usage, nil
+}
+
+func getQuotaUsage(db orm.DB, uid int64, quota Quota) (*QuotaUsage, error) {
+	devices, err := db.Model((*Device)(nil)).Where("owner = ?", uid).Count()
+	if err != nil {
+		return nil, err
+	}
+
+	since := time.Now().Add(-QuotaWindow)
+
+	leases := &[]Lease{}
+	err = db.Model(leases).
+		Where("user_id = ?", uid).
+		WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+			return q.Where("ended_at IS NULL").WhereOr("ended_at > ?", since), nil
+		}).
+		Select()
+	if err != nil {
+		return nil, err
+	}
+
+	var used time.Duration
+	for _, lease := range *leases {
+		start, end := lease.StartedAt, lease.ExpiresAt
+		if !lease.IsActive() {
+			end = lease.EndedAt
+		}
+		if start.Before(since) {
+			start = since
+		}
+		if end.After(start) {
+			used += end.Sub(start)
+		}
+	}
+
+	return &QuotaUsage{
+		Quota:         quota,
+		Devices:       devices,
+		HoursThisWeek: used.Hours(),
+	}, nil
+}
+
+// Check makes sure `devices` more devices can be owned, and their leases
+// extended by `leased` in total, without exceeding the `Quota`
+func (u *QuotaUsage) Check(devices int, leased time.Duration) *JSONError {
+	if u.MaxDevices > 0 && u.Devices+devices > u.MaxDevices {
+		return &JSONError{
+			Status: http.StatusForbidden,
+			Error: fmt.Sprintf("quota exceeded: at most %d devices can be owned at once (%d owned).",
+				u.MaxDevices, u.Devices),
+		}
+	}
+
+	hours := u.HoursThisWeek + leased.Hours()
+	if u.MaxHoursPerWeek > 0 && hours > float64(u.MaxHoursPerWeek) {
+		return &JSONError{
+			Status: http.StatusForbidden,
+			Error: fmt.Sprintf("quota exceeded: at most %d lease hours per week (%.1f used).",
+				u.MaxHoursPerWeek, u.HoursThisWeek),
+		}
+	}
+
+	return nil
+}
+
+// checkQuota locks the `User` with the given `uid`, so that its concurrent
+// acquisitions are counted one after the other, and makes sure it can own
+// `devices` more devices, and extend their leases by `leased` in total,
+// without exceeding the given quota of its role with its overrides applied
+func checkQuota(tx orm.DB, uid int64, roleQuota Quota, devices int, leased time.Duration) *JSONError {
+	quota, jsonErr := lockUserQuota(tx, uid, roleQuota)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	return checkQuotaUsage(tx, uid, quota, devices, leased)
+}
+
+// lockUserQuota locks the `User` with the given `uid` and returns its
+// `Quota`, which is the given quota of its role with its overrides applied
+func lockUserQuota(tx orm.DB, uid int64, roleQuota Quota) (Quota, *JSONError) {
+	user := &User{ID: uid}
+	if err := tx.Model(user).WherePK().For("UPDATE").Select(); err != nil {
+		if err == pg.ErrNoRows {
+			return Quota{}, &JSONError{
+				Status: http.StatusNotFound,
+				Error:  "user does not exist.",
+			}
+		}
+		return Quota{}, NewInternalServerError()
+	}
+
+	return user.Quota(roleQuota), nil
+}
+
+// checkQuotaUsage makes sure the `User` with the given `uid` can own
+// `devices` more devices, and extend their leases by `leased` in total,
+// without exceeding the given `Quota`
+func checkQuotaUsage(tx orm.DB, uid int64, quota Quota, devices int, leased time.Duration) *JSONError {
+	usage, err := getQuotaUsage(tx, uid, quota)
+	if err != nil {
+		return NewInternalServerError()
+	}
+
+	return usage.Check(devices, leased)
+}
@@ -0,0 +1,261 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
+)
+
+// ReservationStatus is an enum which specify the status of a `Reservation`
+type ReservationStatus string
+
+// Values for `ReservationStatus` enum
+const (
+	EnumReservationPending   ReservationStatus = "pending"
+	EnumReservationActive    ReservationStatus = "active"
+	EnumReservationCompleted ReservationStatus = "completed"
+	EnumReservationCanceled  ReservationStatus = "canceled"
+	EnumReservationFailed    ReservationStatus = "failed"
+)
+
+// Reservation is the booking of one or many `Device` by a `User` over a
+// future time window. When the window starts, the devices are acquired for
+// the user until its end.
+type Reservation struct {
+	ID        int64             `json:"id" pg:",pk" example:"1"`
+	UserID    int64             `json:"userId" pg:",notnull" example:"1"`
+	DeviceIDs []int64           `json:"deviceIds" pg:"device_ids,array,notnull" example:"1,2,3"`
+	StartsAt  time.Time         `json:"startsAt" pg:",notnull"`
+	EndsAt    time.Time         `json:"endsAt" pg:",notnull"`
+	Status    ReservationStatus `json:"status" pg:",notnull" example:"pending"`
+	Error     string            `json:"error"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// NewReservation is the model sent to book devices
+type NewReservation struct {
+	Devices  []int64   `json:"devices" example:"1,2,3"`
+	StartsAt time.Time `json:"startsAt" example:"2020-06-15T08:00:00Z"`
+	EndsAt   time.Time `json:"endsAt" example:"2020-06-15T12:00:00Z"`
+}
+
+// IsDone returns true if the `Reservation` will not acquire any device
+// anymore
+func (r *Reservation) IsDone() bool {
+	return r.Status == EnumReservationCompleted || r.Status == EnumReservationCanceled ||
+		r.Status == EnumReservationFailed
+}
+
+// AddReservation inserts a new `Reservation` into the database, unless any of
+// its devices is already booked during its window. The devices are locked
+// meanwhile, so that concurrent reservations cannot book them twice. If
+// `roleQuota` is not nil, the devices leased over the whole window must fit
+// in the `Quota` of the reserving `User`, given the quota of its role.
+func AddReservation(db *pg.DB, reservation *Reservation, roleQuota *Quota) *JSONError {
+	reservation.Status = EnumReservationPending
+	reservation.CreatedAt = time.Now()
+
+	var jsonErr *JSONError
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		// the user is locked before the devices, like the acquisitions do.
+		// The current usage is not counted, since the devices may have been
+		// released by then: the quota is checked again when the devices are
+		// acquired.
+		if roleQuota != nil {
+			var quota Quota
+			if quota, jsonErr = lockUserQuota(tx, reservation.UserID, *roleQuota); jsonErr != nil {
+				return errors.New(jsonErr.Error)
+			}
+
+			count := len(reservation.DeviceIDs)
+			usage := QuotaUsage{Quota: quota}
+			leased := time.Duration(count) * reservation.EndsAt.Sub(reservation.StartsAt)
+			if jsonErr = usage.Check(count, leased); jsonErr != nil {
+				return errors.New(jsonErr.Error)
+			}
+		}
+
+		devices := []Device{}
+		err := tx.Model(&devices).
+			Column("id").
+			Where("id IN (?)", pg.In(reservation.DeviceIDs)).
+			Order("id").
+			For("UPDATE").
+			Select()
+		if err != nil {
+			return err
+		}
+
+		if len(devices) != len(reservation.DeviceIDs) {
+			return pg.ErrNoRows
+		}
+
+		overlapping, err := getOverlappingReservations(tx, reservation.DeviceIDs, reservation.StartsAt, reservation.EndsAt)
+		if err != nil {
+			return err
+		}
+
+		if len(overlapping) > 0 {
+			jsonErr = &JSONError{
+				Status: http.StatusConflict,
+				Error:  fmt.Sprintf("devices are already booked by reservation %d.", overlapping[0].ID),
+			}
+			return errors.New(jsonErr.Error)
+		}
+
+		return tx.Insert(reservation)
+	})
+	if jsonErr != nil {
+		return jsonErr
+	}
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return &JSONError{
+				Status: http.StatusNotFound,
+				Error:  "device does not exist.",
+			}
+		}
+		return NewInternalServerError()
+	}
+
+	return nil
+}
+
+// GetReservation returns the `Reservation` with the given `reservationID`
+// from the database
+func GetReservation(db *pg.DB, reservationID int64) (*Reservation, *JSONError) {
+	reservation := &Reservation{ID: reservationID}
+	if err := db.Select(reservation); err != nil {
+		if err == pg.ErrNoRows {
+			return nil, &JSONError{
+				Status: http.StatusNotFound,
+				Error:  "reservation does not exist.",
+			}
+		}
+		return nil, NewInternalServerError()
+	}
+
+	return reservation, nil
+}
+
+// GetAllReservations returns all the `Reservation` from the database, by
+// start time. If `userID` is not nil, only the reservations of this `User`
+// are returned, and if `deviceID` is not nil, only the ones booking this
+// `Device`. The completed, canceled and failed reservations are only returned
+// if `withDone` is true.
+func GetAllReservations(db *pg.DB, userID, deviceID *int64, withDone bool) (*[]Reservation, *JSONError) {
+	reservations := &[]Reservation{}
+	query := db.Model(reservations).Order("starts_at", "id")
+	if userID != nil {
+		query = query.Where("user_id = ?", *userID)
+	}
+	if deviceID != nil {
+		query = query.Where("? = ANY(device_ids)", *deviceID)
+	}
+	if !withDone {
+		query = query.Where("status IN (?)", pg.In([]ReservationStatus{EnumReservationPending, EnumReservationActive}))
+	}
+
+	if err := query.Select(); err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	return reservations, nil
+}
+
+// GetOverlappingReservations returns the pending and active `Reservation`
+// booking any of the devices with the given `deviceIDs` between `from` and
+// `to`
+func GetOverlappingReservations(db *pg.DB, deviceIDs []int64, from, to time.Time) (*[]Reservation, *JSONError) {
+	reservations, err := getOverlappingReservations(db, deviceIDs, from, to)
+	if err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	return &reservations, nil
+}
+
+func getOverlappingReservations(db orm.DB, deviceIDs []int64, from, to time.Time) ([]Reservation, error) {
+	reservations := []Reservation{}
+	err := db.Model(&reservations).
+		Where("status IN (?)", pg.In([]ReservationStatus{EnumReservationPending, EnumReservationActive})).
+		Where("device_ids && ?", pg.Array(deviceIDs)).
+		Where("starts_at < ?", to).
+		Where("ends_at > ?", from).
+		Order("starts_at").
+		Select()
+
+	return reservations, err
+}
+
+// CheckReservations makes sure none of the devices with the given
+// `deviceIDs` is booked between `from` and `to` by another `User` than the
+// one with the given `userID`
+func CheckReservations(db *pg.DB, deviceIDs []int64, from, to time.Time, userID int64) *JSONError {
+	return checkReservations(db, deviceIDs, from, to, userID)
+}
+
+func checkReservations(db orm.DB, deviceIDs []int64, from, to time.Time, userID int64) *JSONError {
+	reservations, err := getOverlappingReservations(db, deviceIDs, from, to)
+	if err != nil {
+		return NewInternalServerError()
+	}
+
+	for _, reservation := range reservations {
+		if reservation.UserID != userID {
+			return &JSONError{
+				Status: http.StatusConflict,
+				Error: fmt.Sprintf("device is reserved from %s to %s.",
+					reservation.StartsAt.Format(time.RFC3339), reservation.EndsAt.Format(time.RFC3339)),
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetDueReservations returns the pending `Reservation` whose window has
+// started
+func GetDueReservations(db *pg.DB) (*[]Reservation, *JSONError) {
+	reservations := &[]Reservation{}
+	err := db.Model(reservations).
+		Where("status = ?", EnumReservationPending).
+		Where("starts_at <= ?", time.Now()).
+		Order("starts_at").
+		Select()
+	if err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	return reservations, nil
+}
+
+// CompleteEndedReservations marks the pending and active `Reservation` whose
+// window has ended as completed
+func CompleteEndedReservations(db *pg.DB) *JSONError {
+	_, err := db.Model((*Reservation)(nil)).
+		Set("status = ?", EnumReservationCompleted).
+		Where("status IN (?)", pg.In([]ReservationStatus{EnumReservationPending, EnumReservationActive})).
+		Where("ends_at <= ?", time.Now()).
+		Update()
+	if err != nil {
+		return NewInternalServerError()
+	}
+
+	return nil
+}
+
+// UpdateReservationStatus saves the `Status` and `Error` of the given
+// `Reservation`
+func UpdateReservationStatus(db *pg.DB, reservation *Reservation) *JSONError {
+	_, err := db.Model(reservation).Column("status", "error").WherePK().Update()
+	if err != nil {
+		return NewInternalServerError()
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
+)
+
+// Snapshot is an archive of the upper layer of a `Device`'s overlay, i.e.
+// everything written on top of the deployed `Image`. It can be restored on
+// the same or on another device.
+type Snapshot struct {
+	ID          int64     `json:"id" pg:",pk" example:"1"`
+	Name        string    `json:"name" pg:",notnull" example:"lab-1"`
+	Description string    `json:"description" example:"configured for the first lab"`
+	DeviceID    int64     `json:"deviceId" example:"1"`
+	OwnerID     int64     `json:"ownerId" example:"1"`
+	ImageID     *int64    `json:"imageId" example:"1"`
+	Size        int64     `json:"size" pg:",use_zero" example:"52428800"`
+	Path        string    `json:"-" pg:",notnull"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// NewSnapshot is the model sent to snapshot a `Device`
+type NewSnapshot struct {
+	Name        string `json:"name" example:"lab-1"`
+	Description string `json:"description" example:"configured for the first lab"`
+}
+
+// RestoreSnapshot is the model sent to restore a `Snapshot`
+type RestoreSnapshot struct {
+	Device *int64 `json:"device" example:"2"`
+}
+
+// SnapshotQuota limits the snapshots kept by each `User`: their number, and
+// their total size in bytes. A limit of 0 means unlimited.
+type SnapshotQuota struct {
+	MaxCount int
+	MaxSize  int64
+}
+
+// Check makes sure a snapshot of `size` bytes can be added to the `count`
+// snapshots, of `used` bytes in total, already kept by a `User`
+func (q SnapshotQuota) Check(count int, used, size int64) *JSONError {
+	if q.MaxCount > 0 && count >= q.MaxCount {
+		return &JSONError{
+			Status: http.StatusForbidden,
+			Error:  fmt.Sprintf("snapshot quota reached: %d snapshots at most.", q.MaxCount),
+		}
+	}
+
+	if q.MaxSize > 0 && (used >= q.MaxSize || used+size > q.MaxSize) {
+		return &JSONError{
+			Status: http.StatusForbidden,
+			Error:  fmt.Sprintf("snapshot quota reached: %d MiB at most.", q.MaxSize>>20),
+		}
+	}
+
+	return nil
+}
+
+// AddSnapshot inserts a new `Snapshot` into the database, unless it exceeds
+// the given `quota` of its owner. The owner is locked meanwhile, so that
+// concurrent snapshots cannot exceed it together.
+func AddSnapshot(db *pg.DB, snapshot *Snapshot, quota SnapshotQuota) *JSONError {
+	snapshot.CreatedAt = time.Now()
+
+	var jsonErr *JSONError
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		err := tx.Model(&User{ID: snapshot.OwnerID}).Column("id").WherePK().For("UPDATE").Select()
+		if err != nil {
+			return err
+		}
+
+		count, used, err := getSnapshotUsage(tx, snapshot.OwnerID)
+		if err != nil {
+			return err
+		}
+
+		if jsonErr = quota.Check(count, used, snapshot.Size); jsonErr != nil {
+			return errors.New(jsonErr.Error)
+		}
+
+		return tx.Insert(snapshot)
+	})
+	if jsonErr != nil {
+		return jsonErr
+	}
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return &JSONError{
+				Status: http.StatusNotFound,
+				Error:  "user does not exist.",
+			}
+		}
+		return NewInternalServerError()
+	}
+
+	return nil
+}
+
+// GetSnapshot returns the `Snapshot` with the given `snapshotID` from the
+// database
+func GetSnapshot(db *pg.DB, snapshotID int64) (*Snapshot, *JSONError) {
+	snapshot := &Snapshot{ID: snapshotID}
+	if err := db.Select(snapshot); err != nil {
+		if err == pg.ErrNoRows {
+			return nil, &JSONError{
+				Status: http.StatusNotFound,
+				Error:  "snapshot does not exist.",
+			}
+		}
+		return nil, NewInternalServerError()
+	}
+
+	return snapshot, nil
+}
+
+// GetAllSnapshots returns all the `Snapshot` from the database, most recent
+// first. If `ownerID` is not nil, only the snapshots of this `User` are
+// returned, and if `deviceID` is not nil, only the snapshots taken from this
+// `Device`.
+func GetAllSnapshots(db *pg.DB, ownerID, deviceID *int64) (*[]Snapshot, *JSONError) {
+	snapshots := &[]Snapshot{}
+	query := db.Model(snapshots).Order("id DESC")
+	if ownerID != nil {
+		query = query.Where("owner_id = ?", *ownerID)
+	}
+	if deviceID != nil {
+		query = query.Where("device_id = ?", *deviceID)
+	}
+
+	if err := query.Select(); err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	return snapshots, nil
+}
+
+// GetSnapshotUsage returns the number of `Snapshot` kept by the `User` with
+// the given `ownerID`, and their total size in bytes
+func GetSnapshotUsage(db *pg.DB, ownerID int64) (int, int64, *JSONError) {
+	count, size, err := getSnapshotUsage(db, ownerID)
+	if err != nil {
+		return 0, 0, NewInternalServerError()
+	}
+
+	return count, size, nil
+}
+
+func getSnapshotUsage(db orm.DB, ownerID int64) (int, int64, error) {
+	var count int
+	var size int64
+
+	err := db.Model((*Snapshot)(nil)).
+		ColumnExpr("count(*)").
+		ColumnExpr("coalesce(sum(size), 0)").
+		Where("owner_id = ?", ownerID).
+		Select(&count, &size)
+
+	return count, size, err
+}
+
+// DeleteSnapshot removes the `Snapshot` with the given `snapshotID` from the
+// database
+func DeleteSnapshot(db *pg.DB, snapshotID int64) *JSONError {
+	snapshot := &Snapshot{ID: snapshotID}
+	if err := db.Delete(snapshot); err != nil {
+		if err == pg.ErrNoRows {
+			return &JSONError{
+				Status: http.StatusNotFound,
+				Error:  "snapshot does not exist.",
+			}
+		}
+		return NewInternalServerError()
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHKey is an SSH public key of a `User`, installed on the devices they
+// deploy
+type SSHKey struct {
+	ID          int64     `json:"id" pg:",pk" example:"1"`
+	UserID      int64     `json:"userId" pg:",notnull,unique:user_fingerprint" example:"1"`
+	Name        string    `json:"name" example:"laptop"`
+	Type        string    `json:"type" example:"ssh-ed25519"`
+	PublicKey   string    `json:"publicKey" pg:",notnull" example:"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIH1qtV5W4Hq8wR1Rvo6yTUZlG3xXTxo1LFmVqxMqT2hA"`
+	Fingerprint string    `json:"fingerprint" pg:",notnull,unique:user_fingerprint" example:"SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// NewSSHKey is the model sent to add an `SSHKey`
+type NewSSHKey struct {
+	Name      string `json:"name" example:"laptop"`
+	PublicKey string `json:"publicKey" example:"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIH1qtV5W4Hq8wR1Rvo6yTUZlG3xXTxo1LFmVqxMqT2hA alice@laptop"`
+}
+
+// PutSSHKey is the model sent to rename an `SSHKey`
+type PutSSHKey struct {
+	Name string `json:"name" example:"desktop"`
+}
+
+// ParseSSHKey validates the given `publicKey`, written in the
+// `authorized_keys` format, and returns the corresponding `SSHKey`. Without
+// `name`, the comment of the key is used.
+func ParseSSHKey(name, publicKey string) (*SSHKey, *JSONError) {
+	key, comment, options, rest, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil || len(options) > 0 || len(strings.TrimSpace(string(rest))) > 0 {
+		return nil, &JSONError{
+			Status: http.StatusBadRequest,
+			Error:  "public key is not valid.",
+		}
+	}
+
+	if name == "" {
+		name = comment
+	}
+
+	return &SSHKey{
+		Name:        name,
+		Type:        key.Type(),
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		Fingerprint: ssh.FingerprintSHA256(key),
+	}, nil
+}
+
+// AuthorizedKey returns the line installing the `SSHKey` into an
+// `authorized_keys` file, commented with its name
+func (k *SSHKey) AuthorizedKey() string {
+	if k.Name == "" {
+		return k.PublicKey
+	}
+	return k.PublicKey + " " + strings.Join(strings.Fields(k.Name), "-")
+}
+
+// AddSSHKey inserts a new `SSHKey` into the database
+func AddSSHKey(db *pg.DB, key *SSHKey) *JSONError {
+	key.CreatedAt = time.Now()
+
+	if err := db.Insert(key); err != nil {
+		if pgErr, ok := err.(pg.Error); ok && pgErr.IntegrityViolation() {
+			return &JSONError{
+				Status: http.StatusConflict,
+				Error:  "public key already exists.",
+			}
+		}
+		return NewInternalServerError()
+	}
+
+	return nil
+}
+
+// GetSSHKey returns the `SSHKey` with the given `keyID` of the `User` with
+// the given `userID` from the database
+func GetSSHKey(db *pg.DB, userID, keyID int64) (*SSHKey, *JSONError) {
+	key := &SSHKey{}
+	err := db.Model(key).Where("id = ?", keyID).Where("user_id = ?", userID).Select()
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, &JSONError{
+				Status: http.StatusNotFound,
+				Error:  "public key does not exist.",
+			}
+		}
+		return nil, NewInternalServerError()
+	}
+
+	return key, nil
+}
+
+// GetSSHKeysByUser returns all the `SSHKey` of the `User` with the given
+// `userID` from the database
+func GetSSHKeysByUser(db *pg.DB, userID int64) (*[]SSHKey, *JSONError) {
+	keys := &[]SSHKey{}
+	if err := db.Model(keys).Where("user_id = ?", userID).Order("id").Select(); err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	return keys, nil
+}
+
+// RenameSSHKey saves the `Name` of the given `SSHKey`
+func RenameSSHKey(db *pg.DB, key *SSHKey) *JSONError {
+	if _, err := db.Model(key).Column("name").WherePK().Update(); err != nil {
+		return NewInternalServerError()
+	}
+
+	return nil
+}
+
+// DeleteSSHKey removes the `SSHKey` with the given `keyID` of the `User` with
+// the given `userID` from the database
+func DeleteSSHKey(db *pg.DB, userID, keyID int64) *JSONError {
+	res, err := db.Model((*SSHKey)(nil)).Where("id = ?", keyID).Where("user_id = ?", userID).Delete()
+	if err != nil {
+		return NewInternalServerError()
+	}
+
+	if res.RowsAffected() == 0 {
+		return &JSONError{
+			Status: http.StatusNotFound,
+			Error:  "public key does not exist.",
+		}
+	}
+
+	return nil
+}
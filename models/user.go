@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
 	"github.com/labstack/echo/v4"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -27,6 +28,10 @@ type User struct {
 	Role         Role      `json:"role" example:"administrator"`
 	Expiration   time.Time `json:"expiration" example:"2020-05-18"`
 	PasswordHash string    `json:"-" pg:",notnull"`
+
+	// overrides of the `Quota` of the user's role, if not nil
+	MaxDevices      *int `json:"maxDevices" example:"4"`
+	MaxHoursPerWeek *int `json:"maxHoursPerWeek" example:"168"`
 }
 
 // NewUser is the model sent to create a new `User`
@@ -213,16 +218,122 @@ func UpdateUser(db *pg.DB, uid int64, uu *User) (*User, *JSONError) {
 	return u, nil
 }
 
-// DeleteUser removes the given Rubus `User` from the database
-func DeleteUser(db *pg.DB, uid int64) *JSONError {
-	user := &User{ID: uid}
-	if err := db.Delete(user); err != nil {
+// DeleteUser removes the given Rubus `User` from the database, along with
+// their SSH keys, notifications and snapshots, after releasing the devices
+// they own and canceling their pending requests. It returns the released
+// devices and the deleted snapshots, whose archives are left to remove.
+func DeleteUser(db *pg.DB, uid int64) (*[]Device, *[]Snapshot, *JSONError) {
+	devices := &[]Device{}
+	snapshots := &[]Snapshot{}
+
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		if err := tx.Model(devices).Where("owner = ?", uid).For("UPDATE").Select(); err != nil {
+			return err
+		}
+		if err := revokeUserDevices(tx, uid, "user has been deleted."); err != nil {
+			return err
+		}
+		if err := cancelUserRequests(tx, uid); err != nil {
+			return err
+		}
+
+		if _, err := tx.Model(snapshots).Where("owner_id = ?", uid).Returning("*").Delete(); err != nil {
+			return err
+		}
+		if _, err := tx.Model((*SSHKey)(nil)).Where("user_id = ?", uid).Delete(); err != nil {
+			return err
+		}
+		if _, err := tx.Model((*Notification)(nil)).Where("user_id = ?", uid).Delete(); err != nil {
+			return err
+		}
+
+		res, err := tx.Model(&User{ID: uid}).WherePK().Delete()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return pg.ErrNoRows
+		}
+		return nil
+	})
+	if err != nil {
 		if err == pg.ErrNoRows {
-			return &JSONError{
+			return nil, nil, &JSONError{
 				Status: http.StatusNotFound,
 				Error:  "user does not exist.",
 			}
 		}
+		return nil, nil, NewInternalServerError()
+	}
+
+	for i := range *devices {
+		(*devices)[i].Owner = nil
+		(*devices)[i].LeaseExpiresAt = nil
+	}
+
+	return devices, snapshots, nil
+}
+
+// revokeUserDevices releases every `Device` owned by the `User` with the
+// given `uid`, and ends their leases as revoked, explained by the `note`
+func revokeUserDevices(db orm.DB, uid int64, note string) error {
+	_, err := db.Model((*Lease)(nil)).
+		Set("ended_at = ?", time.Now()).
+		Set("end_reason = ?", EnumLeaseRevoked).
+		Set("end_note = ?", note).
+		Where("user_id = ?", uid).
+		Where("ended_at IS NULL").
+		Update()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Model((*Device)(nil)).
+		Set("owner = NULL").
+		Set("lease_expires_at = NULL").
+		Where("owner = ?", uid).
+		Update()
+	return err
+}
+
+// cancelUserRequests cancels the waiting `QueueEntry` and the pending or
+// active `Reservation` of the `User` with the given `uid`
+func cancelUserRequests(db orm.DB, uid int64) error {
+	_, err := db.Model((*QueueEntry)(nil)).
+		Set("status = ?", EnumQueueCanceled).
+		Where("user_id = ?", uid).
+		Where("status = ?", EnumQueueWaiting).
+		Update()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Model((*Reservation)(nil)).
+		Set("status = ?", EnumReservationCanceled).
+		Where("user_id = ?", uid).
+		Where("status IN (?)", pg.In([]ReservationStatus{EnumReservationPending, EnumReservationActive})).
+		Update()
+	return err
+}
+
+// GetExpiredUsers returns the `User` whose expiration date has passed
+func GetExpiredUsers(db *pg.DB) (*[]User, *JSONError) {
+	users := &[]User{}
+	err := db.Model(users).
+		Where("expiration IS NOT NULL").
+		Where("expiration < ?", time.Now()).
+		Select()
+	if err != nil {
+		return nil, NewInternalServerError()
+	}
+
+	return users, nil
+}
+
+// CancelUserRequests cancels the waiting `QueueEntry` and the pending or
+// active `Reservation` of the `User` with the given `uid`
+func CancelUserRequests(db *pg.DB, uid int64) *JSONError {
+	if err := cancelUserRequests(db, uid); err != nil {
 		return NewInternalServerError()
 	}
 
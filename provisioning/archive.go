@@ -0,0 +1,187 @@
+package provisioning
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiveTree writes the content of `src` into `w` as a zstd compressed tar
+// archive, preserving the modes, ownerships and symbolic links. Like in
+// `copyTree`, special files (devices, sockets, pipes) are skipped.
+func archiveTree(fs FS, src string, w io.Writer) error {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+
+	if err := archiveEntry(fs, tw, src, "."); err != nil {
+		zw.Close()
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+func archiveEntry(fs FS, tw *tar.Writer, root, name string) error {
+	path := filepath.Join(root, name)
+	info, err := fs.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	link := ""
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		if link, err = fs.Readlink(path); err != nil {
+			return err
+		}
+	case info.IsDir(), info.Mode().IsRegular():
+	default:
+		return nil
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(name)
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	if info.Mode().IsRegular() {
+		f, err := fs.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		f.Close()
+		return err
+	}
+
+	if !info.IsDir() {
+		return nil
+	}
+
+	entries, err := fs.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := archiveEntry(fs, tw, root, filepath.Join(name, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extractTree extracts the archive written by `archiveTree` from `r` into
+// `dst`. Entries escaping `dst`, or going through a symbolic link extracted
+// before them, are rejected.
+func extractTree(fs FS, r io.Reader, dst string) error {
+	decompressed, err := decompress(r)
+	if err != nil {
+		return err
+	}
+	defer decompressed.Close()
+
+	// the mode of the directories is set last, since it may prevent
+	// writing their content
+	type dirMode struct {
+		path string
+		mode os.FileMode
+	}
+	dirs := []dirMode{}
+
+	tr := tar.NewReader(decompressed)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%s: archive entry is outside of the destination", header.Name)
+		}
+		if err := checkPath(fs, dst, name); err != nil {
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+		path := filepath.Join(dst, name)
+		mode := header.FileInfo().Mode()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := fs.MkdirAll(path, 0700); err != nil {
+				return err
+			}
+			if err := fs.Lchown(path, header.Uid, header.Gid); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirMode{path, mode})
+
+		case tar.TypeReg:
+			f, err := fs.Create(path, 0600)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+			if err := fs.Lchown(path, header.Uid, header.Gid); err != nil {
+				return err
+			}
+			if err := fs.Chmod(path, mode); err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			if err := fs.Symlink(header.Linkname, path); err != nil {
+				return err
+			}
+			if err := fs.Lchown(path, header.Uid, header.Gid); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := fs.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decompress returns the content of the zstd compressed archive read from `r`
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zr.IOReadCloser(), nil
+}
@@ -0,0 +1,131 @@
+package provisioning
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestArchiveTree(t *testing.T) {
+	src := tempDir(t)
+	writeFile(t, filepath.Join(src, "etc", "hostname"), "pi-1\n")
+	if err := os.Symlink("hostname", filepath.Join(src, "etc", "name")); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := &bytes.Buffer{}
+	if err := archiveTree(OSFS{}, src, archive); err != nil {
+		t.Fatalf("archiveTree: %s", err)
+	}
+	if !bytes.HasPrefix(archive.Bytes(), []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+		t.Fatal("archive is not zstd compressed")
+	}
+
+	dst := tempDir(t)
+	if err := extractTree(OSFS{}, archive, dst); err != nil {
+		t.Fatalf("extractTree: %s", err)
+	}
+
+	if got := readFile(t, filepath.Join(dst, "etc", "hostname")); got != "pi-1\n" {
+		t.Errorf("hostname = %q", got)
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "etc", "name")); err != nil || target != "hostname" {
+		t.Errorf("link = %q, %v", target, err)
+	}
+}
+
+// testEntry is an entry of an archive written by `writeTestArchive`
+type testEntry struct {
+	name     string
+	typeflag byte
+	content  string
+	linkname string
+}
+
+// writeTestArchive returns a zstd compressed tar archive of the `entries`
+func writeTestArchive(t *testing.T, entries []testEntry) *bytes.Buffer {
+	t.Helper()
+
+	archive := &bytes.Buffer{}
+	zw, err := zstd.NewWriter(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(zw)
+
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Mode:     0644,
+			Size:     int64(len(entry.content)),
+			Typeflag: entry.typeflag,
+			Linkname: entry.linkname,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return archive
+}
+
+func TestExtractTreeOutside(t *testing.T) {
+	archive := writeTestArchive(t, []testEntry{
+		{name: "../escape", typeflag: tar.TypeReg},
+	})
+
+	if err := extractTree(OSFS{}, archive, tempDir(t)); err == nil {
+		t.Fatal("extractTree accepted an entry outside of the destination")
+	}
+}
+
+func TestExtractTreeThroughSymlink(t *testing.T) {
+	outside := tempDir(t)
+
+	tests := []struct {
+		name    string
+		entries []testEntry
+	}{
+		{"file", []testEntry{
+			{name: "etc", typeflag: tar.TypeSymlink, linkname: outside},
+			{name: "etc/hostname", typeflag: tar.TypeReg, content: "pwned\n"},
+		}},
+		{"directory", []testEntry{
+			{name: "etc", typeflag: tar.TypeSymlink, linkname: outside},
+			{name: "etc/hostname/", typeflag: tar.TypeDir},
+		}},
+		{"overwrite", []testEntry{
+			{name: "hostname", typeflag: tar.TypeSymlink, linkname: filepath.Join(outside, "hostname")},
+			{name: "hostname", typeflag: tar.TypeReg, content: "pwned\n"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := writeTestArchive(t, tt.entries)
+
+			err := extractTree(OSFS{}, archive, tempDir(t))
+			if !errors.Is(err, ErrSymlink) {
+				t.Errorf("extractTree = %v, want %v", err, ErrSymlink)
+			}
+			if pathExists(t, filepath.Join(outside, "hostname")) {
+				t.Error("extractTree wrote outside of the destination")
+			}
+		})
+	}
+}
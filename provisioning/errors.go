@@ -0,0 +1,40 @@
+package provisioning
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidHostname is returned when a hostname cannot be used safely
+	// as a directory name
+	ErrInvalidHostname = errors.New("invalid hostname")
+	// ErrTemplateNotFound is returned when an overlay template directory is
+	// missing
+	ErrTemplateNotFound = errors.New("overlay template not found")
+	// ErrNotProvisioned is returned when an operation requires a device which
+	// has not been set up yet
+	ErrNotProvisioned = errors.New("device is not provisioned")
+	// ErrInvalidFirstBoot is returned when a first boot configuration cannot
+	// be rendered safely
+	ErrInvalidFirstBoot = errors.New("invalid first boot configuration")
+	// ErrSymlink is returned when a path of a device's file system goes
+	// through a symbolic link, which could lead outside of the device
+	ErrSymlink = errors.New("path goes through a symbolic link")
+)
+
+// StepError is returned when one of the provisioning steps of a device fails
+type StepError struct {
+	Step     string
+	Hostname string
+	Err      error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Hostname, e.Step, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,213 @@
+package provisioning
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	firstBootScript = "/etc/rubus/firstboot.sh"
+	firstBootUnit   = "/etc/systemd/system/rubus-firstboot.service"
+	firstBootWants  = "/etc/systemd/system/multi-user.target.wants/rubus-firstboot.service"
+)
+
+// the unit runs the first boot script once the network is up, and removes
+// it if it succeeds so that it is not run again at the next boot
+var firstBootUnitContent = `[Unit]
+Description=Rubus first boot configuration
+ConditionPathExists=` + firstBootScript + `
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/sh ` + firstBootScript + `
+ExecStartPost=/bin/rm -f ` + firstBootScript + `
+
+[Install]
+WantedBy=multi-user.target
+`
+
+var (
+	userRegexp    = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+	packageRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9.+:~_-]*$`)
+)
+
+// FirstBoot describes the configuration applied by a device at its first
+// boot after a deployment
+type FirstBoot struct {
+	// DefaultUser is the user receiving the `AuthorizedKeys`
+	DefaultUser string
+	// AuthorizedKeys are the SSH public keys allowed to log in as the
+	// `DefaultUser`
+	AuthorizedKeys []string
+	// Users are the users to create
+	Users []FirstBootUser
+	// Packages are the packages to install
+	Packages []string
+	// RunCmd are shell commands run as root, in order
+	RunCmd []string
+}
+
+// FirstBootUser describes a user created at the first boot of a device
+type FirstBootUser struct {
+	Name           string
+	Groups         []string
+	Sudo           bool
+	AuthorizedKeys []string
+}
+
+// Validate makes sure the first boot configuration can be rendered safely
+func (f *FirstBoot) Validate() error {
+	names := []string{}
+	if len(f.AuthorizedKeys) > 0 {
+		names = append(names, f.DefaultUser)
+	}
+	keys := append([]string{}, f.AuthorizedKeys...)
+	for _, user := range f.Users {
+		names = append(names, user.Name)
+		names = append(names, user.Groups...)
+		keys = append(keys, user.AuthorizedKeys...)
+	}
+
+	for _, name := range names {
+		if !userRegexp.MatchString(name) {
+			return fmt.Errorf("%q: %w", name, ErrInvalidFirstBoot)
+		}
+	}
+
+	for _, key := range keys {
+		if strings.ContainsAny(key, "\r\n") {
+			return fmt.Errorf("ssh key spans multiple lines: %w", ErrInvalidFirstBoot)
+		}
+	}
+
+	for _, pkg := range f.Packages {
+		if !packageRegexp.MatchString(pkg) {
+			return fmt.Errorf("%q: %w", pkg, ErrInvalidFirstBoot)
+		}
+	}
+
+	return nil
+}
+
+// Script renders the shell script applying the first boot configuration
+func (f *FirstBoot) Script() string {
+	var b strings.Builder
+
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString("# generated by Rubus, run once at the first boot after a deployment\n")
+	b.WriteString("set -e\n\n")
+
+	sshKeys := len(f.AuthorizedKeys) > 0
+
+	for _, user := range f.Users {
+		name := shellQuote(user.Name)
+		fmt.Fprintf(&b, "id -u %s >/dev/null 2>&1 || useradd -m -s /bin/bash %s\n", name, name)
+
+		groups := append([]string{}, user.Groups...)
+		if user.Sudo {
+			groups = append(groups, "sudo")
+		}
+		if len(groups) > 0 {
+			fmt.Fprintf(&b, "usermod -a -G %s %s\n", shellQuote(strings.Join(groups, ",")), name)
+		}
+		if user.Sudo {
+			fmt.Fprintf(&b, "echo %s > %s\n",
+				shellQuote(user.Name+" ALL=(ALL) NOPASSWD:ALL"),
+				shellQuote("/etc/sudoers.d/010_rubus-"+user.Name))
+		}
+
+		if len(user.AuthorizedKeys) > 0 {
+			sshKeys = true
+			writeAuthorizedKeys(&b, user.Name, user.AuthorizedKeys)
+		}
+		b.WriteString("\n")
+	}
+
+	if len(f.AuthorizedKeys) > 0 {
+		writeAuthorizedKeys(&b, f.DefaultUser, f.AuthorizedKeys)
+		b.WriteString("\n")
+	}
+
+	if sshKeys {
+		b.WriteString("systemctl enable --now ssh\n\n")
+	}
+
+	if len(f.Packages) > 0 {
+		b.WriteString("export DEBIAN_FRONTEND=noninteractive\n")
+		b.WriteString("apt-get update\n")
+		b.WriteString("apt-get install -y")
+		for _, pkg := range f.Packages {
+			b.WriteString(" " + shellQuote(pkg))
+		}
+		b.WriteString("\n\n")
+	}
+
+	for _, cmd := range f.RunCmd {
+		b.WriteString(cmd + "\n")
+	}
+
+	return b.String()
+}
+
+// writeAuthorizedKeys renders the commands adding the `keys` to the
+// `authorized_keys` of the given `user`, unless they are already there
+func writeAuthorizedKeys(b *strings.Builder, user string, keys []string) {
+	name := shellQuote(user)
+	fmt.Fprintf(b, "home=$(getent passwd %s | cut -d: -f6)\n", name)
+	b.WriteString("mkdir -p \"$home/.ssh\"\n")
+	b.WriteString("touch \"$home/.ssh/authorized_keys\"\n")
+	for _, key := range keys {
+		quoted := shellQuote(key)
+		fmt.Fprintf(b, "grep -qxF %s \"$home/.ssh/authorized_keys\" || printf '%%s\\n' %s >> \"$home/.ssh/authorized_keys\"\n", quoted, quoted)
+	}
+	fmt.Fprintf(b, "chown -R %s: \"$home/.ssh\"\n", name)
+	b.WriteString("chmod 700 \"$home/.ssh\"\n")
+	b.WriteString("chmod 600 \"$home/.ssh/authorized_keys\"\n")
+}
+
+// shellQuote quotes `s` so that it is read as a single word by the shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// writeFirstBoot installs the first boot script and the systemd unit running
+// it into the given root file system. Since the root file system is written
+// by the device, the paths must not go through any symbolic link, which could
+// lead to the file system of the host.
+func (p *Provisioner) writeFirstBoot(root string, firstBoot *FirstBoot) error {
+	wants := filepath.Join(root, firstBootWants)
+	for _, name := range []string{firstBootScript, firstBootUnit, filepath.Dir(firstBootWants)} {
+		if err := checkPath(p.FS, root, name); err != nil {
+			return err
+		}
+	}
+
+	script := filepath.Join(root, firstBootScript)
+	if err := p.FS.MkdirAll(filepath.Dir(script), 0755); err != nil {
+		return err
+	}
+	if err := p.FS.WriteFile(script, []byte(firstBoot.Script()), 0700); err != nil {
+		return err
+	}
+
+	unit := filepath.Join(root, firstBootUnit)
+	if err := p.FS.MkdirAll(filepath.Dir(unit), 0755); err != nil {
+		return err
+	}
+	if err := p.FS.WriteFile(unit, []byte(firstBootUnitContent), 0644); err != nil {
+		return err
+	}
+
+	// the link enabling the unit is left as is if it already exists
+	if ok, err := exists(p.FS, wants); err != nil || ok {
+		return err
+	}
+	if err := p.FS.MkdirAll(filepath.Dir(wants), 0755); err != nil {
+		return err
+	}
+	return p.FS.Symlink(firstBootUnit, wants)
+}
@@ -0,0 +1,185 @@
+package provisioning
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFirstBootValidate(t *testing.T) {
+	valid := func() *FirstBoot {
+		return &FirstBoot{
+			DefaultUser:    "pi",
+			AuthorizedKeys: []string{"ssh-ed25519 AAAA alice@laptop"},
+			Users: []FirstBootUser{
+				{Name: "bob", Groups: []string{"video", "gpio"}, Sudo: true},
+			},
+			Packages: []string{"vim", "python3-pip", "libc6:armhf", "g++"},
+			RunCmd:   []string{"echo 'hello' > /tmp/hello"},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(f *FirstBoot)
+		valid  bool
+	}{
+		{"valid", func(f *FirstBoot) {}, true},
+		{"no keys for an invalid default user", func(f *FirstBoot) {
+			f.DefaultUser = ""
+			f.AuthorizedKeys = nil
+		}, true},
+		{"invalid default user", func(f *FirstBoot) { f.DefaultUser = "Pi" }, false},
+		{"user with a quote", func(f *FirstBoot) { f.Users[0].Name = "bob'; rm -rf /" }, false},
+		{"user too long", func(f *FirstBoot) { f.Users[0].Name = strings.Repeat("a", 33) }, false},
+		{"invalid group", func(f *FirstBoot) { f.Users[0].Groups = []string{"video,root"} }, false},
+		{"multi-line key", func(f *FirstBoot) {
+			f.AuthorizedKeys = []string{"ssh-ed25519 AAAA\nssh-rsa BBBB"}
+		}, false},
+		{"multi-line user key", func(f *FirstBoot) {
+			f.Users[0].AuthorizedKeys = []string{"ssh-ed25519 AAAA\r"}
+		}, false},
+		{"package option", func(f *FirstBoot) { f.Packages = []string{"-o=Dpkg::Options"} }, false},
+		{"package with a space", func(f *FirstBoot) { f.Packages = []string{"vim emacs"} }, false},
+	}
+
+	for _, test := range tests {
+		f := valid()
+		test.modify(f)
+
+		err := f.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%s: Validate = %v, want nil", test.name, err)
+		}
+		if !test.valid && !errors.Is(err, ErrInvalidFirstBoot) {
+			t.Errorf("%s: Validate = %v, want %v", test.name, err, ErrInvalidFirstBoot)
+		}
+	}
+}
+
+func TestFirstBootScript(t *testing.T) {
+	f := &FirstBoot{
+		DefaultUser:    "pi",
+		AuthorizedKeys: []string{"ssh-ed25519 AAAA alice's laptop"},
+		Users: []FirstBootUser{
+			{Name: "bob", Groups: []string{"video", "gpio"}, Sudo: true},
+		},
+		Packages: []string{"vim", "git"},
+		RunCmd:   []string{"echo first", "echo second"},
+	}
+
+	script := f.Script()
+
+	expected := []string{
+		"id -u 'bob' >/dev/null 2>&1 || useradd -m -s /bin/bash 'bob'\n",
+		"usermod -a -G 'video,gpio,sudo' 'bob'\n",
+		"echo 'bob ALL=(ALL) NOPASSWD:ALL' > '/etc/sudoers.d/010_rubus-bob'\n",
+		"home=$(getent passwd 'pi' | cut -d: -f6)\n",
+		`'ssh-ed25519 AAAA alice'\''s laptop'`,
+		"systemctl enable --now ssh\n",
+		"apt-get install -y 'vim' 'git'\n",
+		"echo first\necho second\n",
+	}
+
+	if !strings.HasPrefix(script, "#!/bin/sh\n") {
+		t.Errorf("script does not start with a shebang:\n%s", script)
+	}
+
+	// the lines must appear in order
+	rest := script
+	for _, line := range expected {
+		i := strings.Index(rest, line)
+		if i < 0 {
+			t.Fatalf("script does not contain %q after the previous lines:\n%s", line, script)
+		}
+		rest = rest[i+len(line):]
+	}
+}
+
+func TestFirstBootScriptEmpty(t *testing.T) {
+	script := (&FirstBoot{DefaultUser: "pi"}).Script()
+
+	for _, unexpected := range []string{"useradd", "authorized_keys", "systemctl", "apt-get"} {
+		if strings.Contains(script, unexpected) {
+			t.Errorf("empty configuration renders %q:\n%s", unexpected, script)
+		}
+	}
+}
+
+func TestDeployDeviceFirstBoot(t *testing.T) {
+	p, _ := newTestProvisioner(t)
+	if err := p.AddDevice(context.Background(), "pi-1", ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &FirstBoot{DefaultUser: "pi", AuthorizedKeys: []string{"ssh-ed25519 AAAA"}}
+
+	// deploying twice must keep the link enabling the unit
+	for i := 0; i < 2; i++ {
+		if err := p.DeployDevice(context.Background(), "pi-1", "", f, ioutil.Discard); err != nil {
+			t.Fatalf("DeployDevice: %s", err)
+		}
+	}
+
+	upper := p.UpperDir("pi-1")
+	if readFile(t, filepath.Join(upper, firstBootScript)) != f.Script() {
+		t.Error("the first boot script is not written")
+	}
+	if readFile(t, filepath.Join(upper, firstBootUnit)) != firstBootUnitContent {
+		t.Error("the first boot unit is not written")
+	}
+	target, err := os.Readlink(filepath.Join(upper, firstBootWants))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != firstBootUnit {
+		t.Errorf("the unit is enabled through %q, want %q", target, firstBootUnit)
+	}
+}
+
+func TestDeployDeviceFirstBootSymlink(t *testing.T) {
+	f := &FirstBoot{DefaultUser: "pi", AuthorizedKeys: []string{"ssh-ed25519 AAAA"}}
+
+	// the owner of the device replaced one of the directories, or the files
+	// themselves, by links to the host
+	links := []string{
+		"etc/rubus",
+		"etc/systemd/system",
+		"etc/systemd/system/multi-user.target.wants",
+		strings.TrimPrefix(firstBootScript, "/"),
+		strings.TrimPrefix(firstBootUnit, "/"),
+	}
+
+	for _, link := range links {
+		p, _ := newTestProvisioner(t)
+		if err := p.AddDevice(context.Background(), "pi-1", ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+
+		outside := tempDir(t)
+		path := filepath.Join(p.UpperDir("pi-1"), link)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(outside, "target"), path); err != nil {
+			t.Fatal(err)
+		}
+
+		err := p.DeployDevice(context.Background(), "pi-1", "", f, ioutil.Discard)
+		if !errors.Is(err, ErrSymlink) {
+			t.Errorf("%s: DeployDevice = %v, want %v", link, err, ErrSymlink)
+		}
+
+		files, err := ioutil.ReadDir(outside)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 0 {
+			t.Errorf("%s: wrote %d files outside of the device", link, len(files))
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package provisioning
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FS abstracts the file system operations performed while provisioning a
+// device, so that they can be run against any directory tree
+type FS interface {
+	Lstat(name string) (os.FileInfo, error)
+	MkdirAll(path string, perm os.FileMode) error
+	RemoveAll(path string) error
+	Rename(oldpath, newpath string) error
+	ReadDir(dirname string) ([]os.FileInfo, error)
+	ReadFile(filename string) ([]byte, error)
+	Open(name string) (io.ReadCloser, error)
+	Create(name string, perm os.FileMode) (io.WriteCloser, error)
+	WriteFile(filename string, data []byte, perm os.FileMode) error
+	Readlink(name string) (string, error)
+	Symlink(oldname, newname string) error
+	Chmod(name string, mode os.FileMode) error
+	Lchown(name string, uid, gid int) error
+}
+
+// OSFS is the `FS` of the operating system
+type OSFS struct{}
+
+// Lstat returns the `os.FileInfo` of the named file, without following links
+func (OSFS) Lstat(name string) (os.FileInfo, error) { return os.Lstat(name) }
+
+// MkdirAll creates a directory along with any necessary parents
+func (OSFS) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+
+// RemoveAll removes a path and any children it contains
+func (OSFS) RemoveAll(path string) error { return os.RemoveAll(path) }
+
+// Rename moves `oldpath` to `newpath`
+func (OSFS) Rename(oldpath, newpath string) error { return os.Rename(oldpath, newpath) }
+
+// ReadDir returns the entries of the named directory, sorted by name
+func (OSFS) ReadDir(dirname string) ([]os.FileInfo, error) { return ioutil.ReadDir(dirname) }
+
+// ReadFile returns the content of the named file
+func (OSFS) ReadFile(filename string) ([]byte, error) { return ioutil.ReadFile(filename) }
+
+// Open opens the named file for reading
+func (OSFS) Open(name string) (io.ReadCloser, error) { return os.Open(name) }
+
+// Create creates or truncates the named file for writing
+func (OSFS) Create(name string, perm os.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+}
+
+// WriteFile writes `data` into the named file, creating it if necessary
+func (OSFS) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(filename, data, perm)
+}
+
+// Readlink returns the destination of the named symbolic link
+func (OSFS) Readlink(name string) (string, error) { return os.Readlink(name) }
+
+// Symlink creates `newname` as a symbolic link to `oldname`
+func (OSFS) Symlink(oldname, newname string) error { return os.Symlink(oldname, newname) }
+
+// Chmod changes the mode of the named file
+func (OSFS) Chmod(name string, mode os.FileMode) error { return os.Chmod(name, mode) }
+
+// Lchown changes the owner of the named file, without following links
+func (OSFS) Lchown(name string, uid, gid int) error { return os.Lchown(name, uid, gid) }
+
+// exists returns true if something exists at the given `path`
+func exists(fs FS, path string) (bool, error) {
+	_, err := fs.Lstat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// checkPath makes sure that no component of the path `name`, relative to
+// `root`, is a symbolic link, so that writing to it cannot lead outside of
+// `root`. The checks stop at the first missing component.
+func checkPath(fs FS, root, name string) error {
+	path := root
+	for _, part := range strings.Split(filepath.Clean(name), string(filepath.Separator)) {
+		if part == "." || part == "" {
+			continue
+		}
+
+		path = filepath.Join(path, part)
+		info, err := fs.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s: %w", path, ErrSymlink)
+		}
+	}
+
+	return nil
+}
+
+// copyTree recursively copies `src` into `dst`, preserving the modes,
+// ownerships and symbolic links, like `cp -a` does. Special files (devices,
+// sockets, pipes) are skipped.
+func copyTree(fs FS, src, dst string) error {
+	info, err := fs.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := fs.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := fs.Symlink(target, dst); err != nil {
+			return err
+		}
+		return copyOwner(fs, info, dst)
+
+	case info.IsDir():
+		if err := fs.MkdirAll(dst, 0700); err != nil {
+			return err
+		}
+		entries, err := fs.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := copyTree(fs, filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+				return err
+			}
+		}
+
+	case info.Mode().IsRegular():
+		data, err := fs.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		if err := fs.WriteFile(dst, data, 0600); err != nil {
+			return err
+		}
+
+	default:
+		return nil
+	}
+
+	// the mode is set last, since changing the owner may clear the
+	// setuid and setgid bits
+	if err := copyOwner(fs, info, dst); err != nil {
+		return err
+	}
+	return fs.Chmod(dst, info.Mode())
+}
+
+// copyOwner gives `dst` the owner described by `info`, when it is known
+func copyOwner(fs FS, info os.FileInfo, dst string) error {
+	if uid, gid, ok := fileOwner(info); ok {
+		return fs.Lchown(dst, uid, gid)
+	}
+	return nil
+}
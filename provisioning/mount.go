@@ -0,0 +1,110 @@
+package provisioning
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Mounter abstracts the mount operations performed while provisioning a
+// device
+type Mounter interface {
+	// MountOverlay mounts an overlay file system made of `lower` and `upper`
+	// on `target`
+	MountOverlay(lower, upper, work, target string) error
+	// BindMount makes `source` also available at `target`
+	BindMount(source, target string) error
+	// Unmount detaches the file system mounted on `target`
+	Unmount(target string) error
+	// IsMounted returns true if a file system is mounted on `target`
+	IsMounted(target string) (bool, error)
+}
+
+// ExecMounter is a `Mounter` relying on the `mount` and `umount` commands,
+// which requires the API to run with enough privileges
+type ExecMounter struct {
+	// MountInfo is the file listing the current mount points
+	// (default: /proc/self/mounts)
+	MountInfo string
+}
+
+// MountOverlay mounts an overlay file system made of `lower` and `upper` on
+// `target`
+func (m ExecMounter) MountOverlay(lower, upper, work, target string) error {
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lower, upper, work)
+	return run("mount", "-t", "overlay", "overlay", "-o", options, target)
+}
+
+// BindMount makes `source` also available at `target`
+func (m ExecMounter) BindMount(source, target string) error {
+	return run("mount", "--bind", source, target)
+}
+
+// Unmount detaches the file system mounted on `target`
+func (m ExecMounter) Unmount(target string) error {
+	return run("umount", target)
+}
+
+// IsMounted returns true if a file system is mounted on `target`
+func (m ExecMounter) IsMounted(target string) (bool, error) {
+	mountInfo := m.MountInfo
+	if mountInfo == "" {
+		mountInfo = "/proc/self/mounts"
+	}
+
+	file, err := os.Open(mountInfo)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	target = filepath.Clean(target)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && unescapeMountPath(fields[1]) == target {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+// unescapeMountPath decodes the octal escapes (e.g. `\040` for a space) used
+// in the mount table
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			var c byte
+			if _, err := fmt.Sscanf(path[i+1:i+4], "%03o", &c); err == nil {
+				b.WriteByte(c)
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
+
+func run(name string, args ...string) error {
+	output := &bytes.Buffer{}
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %s: %s", name, strings.Join(args, " "), err, strings.TrimSpace(output.String()))
+	}
+
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package provisioning
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the user and group owning the file described by `info`
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
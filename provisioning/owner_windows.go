@@ -0,0 +1,8 @@
+package provisioning
+
+import "os"
+
+// fileOwner returns false, since file ownership is not available on Windows
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,583 @@
+// Package provisioning prepares the network boot (PXE over TFTP) and the NFS
+// root file system of the devices. Each device gets an overlay file system
+// whose lower layer is shared by every device, and whose upper layer holds
+// everything the device writes.
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Config contains the paths and addresses used to provision the devices
+type Config struct {
+	// NFSRoot is the directory holding the overlay of each device
+	NFSRoot string
+	// TFTPRoot is the directory holding the boot partition of each device
+	TFTPRoot string
+	// ExportsFile is the NFS server exports table
+	ExportsFile string
+	// ExportOptions are the NFS options of each exported overlay
+	ExportOptions string
+	// NFSServer is the address of the NFS server, as seen by the devices
+	NFSServer string
+	// TemplateUpper is the directory copied as the upper layer of each overlay
+	TemplateUpper string
+	// TemplateLower is the read-only lower layer shared by each overlay
+	TemplateLower string
+	// SnapshotDir is the directory holding the snapshots of the overlays
+	SnapshotDir string
+}
+
+// DefaultConfig returns the `Config` matching the layout of the Rubus
+// deployment (see docker-compose.yml)
+func DefaultConfig() Config {
+	return Config{
+		NFSRoot:       "/pxe/nfs",
+		TFTPRoot:      "/tftp",
+		ExportsFile:   "/etc/exports",
+		ExportOptions: "rw,sync,no_subtree_check,no_root_squash",
+		NFSServer:     "172.29.0.100",
+		TemplateUpper: "/pxe/nfs/NFS-TEMPLATE-UPPER",
+		TemplateLower: "/pxe/nfs/NFS-TEMPLATE-LOWER",
+		SnapshotDir:   "/pxe/snapshots",
+	}
+}
+
+var hostnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// Provisioner sets up, deploys and removes the network boot of the devices.
+// Every step is idempotent, so that a failed operation can be run again.
+type Provisioner struct {
+	Config  Config
+	FS      FS
+	Mounter Mounter
+}
+
+// NewProvisioner returns a `Provisioner` working on the real file system
+func NewProvisioner(cfg Config) *Provisioner {
+	return &Provisioner{
+		Config:  cfg,
+		FS:      OSFS{},
+		Mounter: ExecMounter{},
+	}
+}
+
+// UpperDir returns the upper layer of the device's overlay, which is also
+// where the overlay is mounted
+func (p *Provisioner) UpperDir(hostname string) string {
+	return filepath.Join(p.Config.NFSRoot, hostname)
+}
+
+// WorkDir returns the work directory of the device's overlay
+func (p *Provisioner) WorkDir(hostname string) string {
+	return filepath.Join(p.Config.NFSRoot, hostname+"-work")
+}
+
+// TFTPDir returns the directory the device boots from
+func (p *Provisioner) TFTPDir(hostname string) string {
+	return filepath.Join(p.Config.TFTPRoot, hostname)
+}
+
+type step struct {
+	name string
+	run  func() error
+}
+
+// runSteps runs each step in order, stopping at the first failure
+func (p *Provisioner) runSteps(ctx context.Context, hostname string, log io.Writer, steps []step) error {
+	for _, s := range steps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(log, "%s: %s\n", hostname, s.name)
+		if err := s.run(); err != nil {
+			return &StepError{Step: s.name, Hostname: hostname, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// CheckHostname makes sure the given `hostname` can name the directories of
+// a device: it must be a valid hostname, must not end with the suffix of the
+// overlay work directories, and the device's directories must neither hold
+// nor lie within the templates, the snapshots, the exports table or any of
+// the `reserved` paths (e.g. the images)
+func (p *Provisioner) CheckHostname(hostname string, reserved ...string) error {
+	if !hostnameRegexp.MatchString(hostname) || strings.HasSuffix(hostname, "-work") {
+		return ErrInvalidHostname
+	}
+
+	reserved = append([]string{
+		p.Config.TemplateUpper,
+		p.Config.TemplateLower,
+		p.Config.SnapshotDir,
+		p.Config.ExportsFile,
+	}, reserved...)
+	for _, path := range reserved {
+		if p.UsesPath(hostname, path) {
+			return ErrInvalidHostname
+		}
+	}
+
+	return nil
+}
+
+// UsesPath returns true if a directory of the device with the given
+// `hostname` holds or lies within the given `path`
+func (p *Provisioner) UsesPath(hostname, path string) bool {
+	if path == "" {
+		return false
+	}
+
+	for _, dir := range p.DeviceDirs(hostname) {
+		if isWithin(path, dir) || isWithin(dir, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DeviceDirs returns the directories created for the device with the given
+// `hostname`: its upper layer, its work directory and its TFTP directory
+func (p *Provisioner) DeviceDirs(hostname string) []string {
+	return []string{p.UpperDir(hostname), p.WorkDir(hostname), p.TFTPDir(hostname)}
+}
+
+// Provisioned returns true if any directory of the device with the given
+// `hostname` already exists
+func (p *Provisioner) Provisioned(hostname string) (bool, error) {
+	for _, dir := range p.DeviceDirs(hostname) {
+		if ok, err := exists(p.FS, dir); err != nil || ok {
+			return ok, err
+		}
+	}
+
+	return false, nil
+}
+
+func (p *Provisioner) validateHostname(hostname string) error {
+	if err := p.CheckHostname(hostname); err != nil {
+		return &StepError{Step: "validate hostname", Hostname: hostname, Err: err}
+	}
+	return nil
+}
+
+// isWithin returns true if `path` is `dir` or lies inside of it
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// AddDevice prepares the files and folders needed to network boot the device
+// with the given `hostname`: its TFTP directory, its overlay and its NFS
+// export. Progress is written into `log`.
+func (p *Provisioner) AddDevice(ctx context.Context, hostname string, log io.Writer) error {
+	if err := p.validateHostname(hostname); err != nil {
+		return err
+	}
+
+	upper := p.UpperDir(hostname)
+
+	return p.runSteps(ctx, hostname, log, []step{
+		{"create tftp directory", func() error {
+			return p.FS.MkdirAll(p.TFTPDir(hostname), 0755)
+		}},
+		{"copy upper overlay template", func() error {
+			return p.createUpper(hostname)
+		}},
+		{"customise hostname", func() error {
+			return p.customiseHostname(upper, hostname)
+		}},
+		{"write kernel command line", func() error {
+			return p.writeCmdline(upper, hostname)
+		}},
+		{"export nfs share", func() error {
+			return p.addExport(upper)
+		}},
+		{"mount overlay", func() error {
+			return p.mountOverlay(hostname, p.Config.TemplateLower)
+		}},
+	})
+}
+
+// DeployDevice mounts the overlay of the device with the given `hostname` on
+// top of the `lower` layer (i.e. the image to deploy), exposes its boot
+// partition through TFTP and enables it, so that the device boots from the
+// network at its next start. An empty `lower` deploys the default template.
+// The optional `firstBoot` configuration is applied by the device when it
+// boots. Progress is written into `log`.
+func (p *Provisioner) DeployDevice(ctx context.Context, hostname, lower string, firstBoot *FirstBoot, log io.Writer) error {
+	if err := p.validateHostname(hostname); err != nil {
+		return err
+	}
+
+	if lower == "" {
+		lower = p.Config.TemplateLower
+	}
+
+	if firstBoot != nil {
+		if err := firstBoot.Validate(); err != nil {
+			return &StepError{Step: "validate first boot configuration", Hostname: hostname, Err: err}
+		}
+	}
+
+	upper := p.UpperDir(hostname)
+	tftp := p.TFTPDir(hostname)
+
+	steps := []step{
+		{"check provisioning", func() error {
+			ok, err := exists(p.FS, upper)
+			if err == nil && !ok {
+				err = ErrNotProvisioned
+			}
+			return err
+		}},
+		{"unmount boot partition", func() error {
+			return p.unmount(tftp)
+		}},
+		{"unmount overlay", func() error {
+			return p.unmount(upper)
+		}},
+		{"mount overlay on " + lower, func() error {
+			return p.mountOverlay(hostname, lower)
+		}},
+	}
+
+	if firstBoot != nil {
+		// the overlay is mounted on its upper directory, so that the files
+		// are written on top of the image
+		steps = append(steps, step{"write first boot configuration", func() error {
+			return p.writeFirstBoot(upper, firstBoot)
+		}})
+	}
+
+	return p.runSteps(ctx, hostname, log, append(steps, []step{
+		{"bind boot partition", func() error {
+			if err := checkPath(p.FS, upper, "boot"); err != nil {
+				return err
+			}
+			if err := p.FS.MkdirAll(tftp, 0755); err != nil {
+				return err
+			}
+			return p.Mounter.BindMount(filepath.Join(upper, "boot"), tftp)
+		}},
+		{"enable boot files", func() error {
+			return p.enableBoot(upper)
+		}},
+	}...))
+}
+
+// ResetDevice brings the overlay of the device with the given `hostname` back
+// to its pristine state: the upper layer is recreated from the template and
+// customised again, then mounted on top of the `lower` layer (an empty
+// `lower` meaning the default template). The boot files are disabled until
+// the device is deployed again. Progress is written into `log`.
+func (p *Provisioner) ResetDevice(ctx context.Context, hostname, lower string, log io.Writer) error {
+	if err := p.validateHostname(hostname); err != nil {
+		return err
+	}
+
+	if lower == "" {
+		lower = p.Config.TemplateLower
+	}
+
+	upper := p.UpperDir(hostname)
+	tftp := p.TFTPDir(hostname)
+
+	return p.runSteps(ctx, hostname, log, []step{
+		{"unmount boot partition", func() error {
+			return p.unmount(tftp)
+		}},
+		{"unmount overlay", func() error {
+			return p.unmount(upper)
+		}},
+		{"remove overlay", func() error {
+			if err := p.FS.RemoveAll(upper); err != nil {
+				return err
+			}
+			return p.FS.RemoveAll(p.WorkDir(hostname))
+		}},
+		{"copy upper overlay template", func() error {
+			return p.createUpper(hostname)
+		}},
+		{"customise hostname", func() error {
+			return p.customiseHostname(upper, hostname)
+		}},
+		{"write kernel command line", func() error {
+			return p.writeCmdline(upper, hostname)
+		}},
+		{"mount overlay on " + lower, func() error {
+			return p.mountOverlay(hostname, lower)
+		}},
+	})
+}
+
+// CheckImage makes sure the given `path` is a directory which can be used as
+// the lower layer of an overlay
+func (p *Provisioner) CheckImage(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("%s: image path must be absolute", path)
+	}
+
+	info, err := p.FS.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s: image path is not a directory", path)
+	}
+
+	return nil
+}
+
+// DeleteDevice removes everything set up by `AddDevice` and `DeployDevice`
+// for the device with the given `hostname`. Every step is attempted even if
+// a previous one failed, and the first error is returned.
+func (p *Provisioner) DeleteDevice(ctx context.Context, hostname string, log io.Writer) error {
+	if err := p.validateHostname(hostname); err != nil {
+		return err
+	}
+
+	upper := p.UpperDir(hostname)
+	tftp := p.TFTPDir(hostname)
+
+	var firstErr error
+	for _, s := range []step{
+		{"unmount boot partition", func() error { return p.unmount(tftp) }},
+		{"remove tftp directory", func() error { return p.FS.RemoveAll(tftp) }},
+		{"unmount overlay", func() error { return p.unmount(upper) }},
+		{"remove overlay", func() error { return p.FS.RemoveAll(upper) }},
+		{"remove overlay work directory", func() error { return p.FS.RemoveAll(p.WorkDir(hostname)) }},
+		{"remove nfs export", func() error { return p.removeExport(upper) }},
+	} {
+		if err := p.runSteps(ctx, hostname, log, []step{s}); err != nil {
+			fmt.Fprintf(log, "%s\n", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// createUpper copies the upper overlay template for the device, unless its
+// upper directory already exists
+func (p *Provisioner) createUpper(hostname string) error {
+	upper := p.UpperDir(hostname)
+	if ok, err := exists(p.FS, upper); err != nil || ok {
+		return err
+	}
+
+	if ok, err := exists(p.FS, p.Config.TemplateUpper); err != nil || !ok {
+		if err == nil {
+			err = ErrTemplateNotFound
+		}
+		return err
+	}
+
+	return copyTree(p.FS, p.Config.TemplateUpper, upper)
+}
+
+// customiseHostname writes the `hostname` into `/etc/hostname` and
+// `/etc/hosts` of the given root file system
+func (p *Provisioner) customiseHostname(root, hostname string) error {
+	for _, name := range []string{"hostname", "hosts"} {
+		if err := checkPath(p.FS, root, filepath.Join("etc", name)); err != nil {
+			return err
+		}
+	}
+
+	if err := p.FS.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		return err
+	}
+
+	if err := p.FS.WriteFile(filepath.Join(root, "etc", "hostname"), []byte(hostname+"\n"), 0644); err != nil {
+		return err
+	}
+
+	hostsPath := filepath.Join(root, "etc", "hosts")
+	hosts, err := p.FS.ReadFile(hostsPath)
+	if err != nil {
+		if ok, _ := exists(p.FS, hostsPath); ok {
+			return err
+		}
+		hosts = []byte("127.0.0.1\tlocalhost\n")
+	}
+
+	// drop the default hostname and any previous customisation
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimRight(string(hosts), "\n"), "\n") {
+		if strings.Contains(line, "raspberrypi") || strings.HasPrefix(line, "127.0.1.1") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "127.0.1.1\t"+hostname)
+
+	return p.FS.WriteFile(hostsPath, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// writeCmdline configures the kernel of the device to mount its root file
+// system from the NFS share
+func (p *Provisioner) writeCmdline(upper, hostname string) error {
+	disabled := filepath.Join(upper, "boot", "disabled")
+	if err := checkPath(p.FS, upper, filepath.Join("boot", "disabled", "cmdline.txt")); err != nil {
+		return err
+	}
+	if err := p.FS.MkdirAll(disabled, 0755); err != nil {
+		return err
+	}
+
+	cmdline := fmt.Sprintf("console=serial0,115200 console=tty1 root=/dev/nfs "+
+		"nfsroot=%s:%s,vers=3 rw ip=dhcp rootwait elevator=deadline\n",
+		p.Config.NFSServer, p.UpperDir(hostname))
+
+	return p.FS.WriteFile(filepath.Join(disabled, "cmdline.txt"), []byte(cmdline), 0644)
+}
+
+// mountOverlay mounts the overlay of the device, made of the given `lower`
+// layer and of its upper directory, unless it is already mounted
+func (p *Provisioner) mountOverlay(hostname, lower string) error {
+	upper := p.UpperDir(hostname)
+
+	mounted, err := p.Mounter.IsMounted(upper)
+	if err != nil || mounted {
+		return err
+	}
+
+	if ok, err := exists(p.FS, lower); err != nil || !ok {
+		if err == nil {
+			err = ErrTemplateNotFound
+		}
+		return err
+	}
+
+	work := p.WorkDir(hostname)
+	if err := p.FS.MkdirAll(work, 0755); err != nil {
+		return err
+	}
+
+	return p.Mounter.MountOverlay(lower, upper, work, upper)
+}
+
+// enableBoot moves the boot files out of the `disabled` directory of the
+// boot partition, if they have not been moved yet
+func (p *Provisioner) enableBoot(upper string) error {
+	boot := filepath.Join(upper, "boot")
+	disabled := filepath.Join(boot, "disabled")
+
+	if err := checkPath(p.FS, upper, filepath.Join("boot", "disabled")); err != nil {
+		return err
+	}
+
+	if ok, err := exists(p.FS, filepath.Join(disabled, "start4.elf")); err != nil || !ok {
+		return err
+	}
+
+	entries, err := p.FS.ReadDir(disabled)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := p.FS.Rename(filepath.Join(disabled, entry.Name()), filepath.Join(boot, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Provisioner) unmount(target string) error {
+	mounted, err := p.Mounter.IsMounted(target)
+	if err != nil || !mounted {
+		return err
+	}
+	return p.Mounter.Unmount(target)
+}
+
+func (p *Provisioner) exportLine(upper string) string {
+	return fmt.Sprintf("%s *(%s)", upper, p.Config.ExportOptions)
+}
+
+// addExport adds the NFS export of the overlay, unless it is already exported
+func (p *Provisioner) addExport(upper string) error {
+	lines, err := p.readExports()
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		if exportPath(line) == upper {
+			return nil
+		}
+	}
+
+	lines = append(lines, p.exportLine(upper))
+	return p.writeExports(lines)
+}
+
+// removeExport removes the NFS export of the overlay
+func (p *Provisioner) removeExport(upper string) error {
+	lines, err := p.readExports()
+	if err != nil {
+		return err
+	}
+
+	kept := []string{}
+	for _, line := range lines {
+		if exportPath(line) != upper {
+			kept = append(kept, line)
+		}
+	}
+
+	if len(kept) == len(lines) {
+		return nil
+	}
+	return p.writeExports(kept)
+}
+
+func (p *Provisioner) readExports() ([]string, error) {
+	content, err := p.FS.ReadFile(p.Config.ExportsFile)
+	if err != nil {
+		if ok, _ := exists(p.FS, p.Config.ExportsFile); !ok {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	trimmed := strings.TrimRight(string(content), "\n")
+	if trimmed == "" {
+		return []string{}, nil
+	}
+	return strings.Split(trimmed, "\n"), nil
+}
+
+// writeExports rewrites the exports table in place, since it may be bind
+// mounted from the host and cannot be replaced
+func (p *Provisioner) writeExports(lines []string) error {
+	content := ""
+	if len(lines) > 0 {
+		content = strings.Join(lines, "\n") + "\n"
+	}
+	return p.FS.WriteFile(p.Config.ExportsFile, []byte(content), 0644)
+}
+
+// exportPath returns the exported path of a line of the exports table
+func exportPath(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return ""
+	}
+	return filepath.Clean(fields[0])
+}
@@ -0,0 +1,468 @@
+package provisioning
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeMounter records the mount points instead of mounting anything. When
+// `failMounts` is positive, that many overlay mounts fail first.
+type fakeMounter struct {
+	mounts     map[string]string
+	failMounts int
+}
+
+func newFakeMounter() *fakeMounter {
+	return &fakeMounter{mounts: map[string]string{}}
+}
+
+func (m *fakeMounter) MountOverlay(lower, upper, work, target string) error {
+	if m.failMounts > 0 {
+		m.failMounts--
+		return errors.New("mount failed")
+	}
+	return m.mount(lower, target)
+}
+
+func (m *fakeMounter) BindMount(source, target string) error {
+	return m.mount(source, target)
+}
+
+func (m *fakeMounter) mount(source, target string) error {
+	if _, ok := m.mounts[target]; ok {
+		return errors.New(target + " is already mounted")
+	}
+	m.mounts[target] = source
+	return nil
+}
+
+func (m *fakeMounter) Unmount(target string) error {
+	if _, ok := m.mounts[target]; !ok {
+		return errors.New(target + " is not mounted")
+	}
+	delete(m.mounts, target)
+	return nil
+}
+
+func (m *fakeMounter) IsMounted(target string) (bool, error) {
+	_, ok := m.mounts[target]
+	return ok, nil
+}
+
+// newTestProvisioner returns a `Provisioner` working in a temporary
+// directory, along with its templates
+func newTestProvisioner(t *testing.T) (*Provisioner, *fakeMounter) {
+	root := tempDir(t)
+	cfg := Config{
+		NFSRoot:       filepath.Join(root, "nfs"),
+		TFTPRoot:      filepath.Join(root, "tftp"),
+		ExportsFile:   filepath.Join(root, "exports"),
+		ExportOptions: "rw,sync",
+		NFSServer:     "10.0.0.1",
+		TemplateUpper: filepath.Join(root, "template-upper"),
+		TemplateLower: filepath.Join(root, "template-lower"),
+		SnapshotDir:   filepath.Join(root, "snapshots"),
+	}
+
+	writeFile(t, filepath.Join(cfg.TemplateUpper, "etc", "hosts"), "127.0.0.1\tlocalhost\n127.0.1.1\traspberrypi\n")
+	writeFile(t, filepath.Join(cfg.TemplateUpper, "boot", "disabled", "start4.elf"), "firmware")
+	writeFile(t, filepath.Join(cfg.TemplateLower, "etc", "os-release"), "rubus")
+
+	mounter := newFakeMounter()
+	return &Provisioner{Config: cfg, FS: OSFS{}, Mounter: mounter}, mounter
+}
+
+// tempDir returns a temporary directory removed at the end of the test
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "provisioning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func pathExists(t *testing.T, path string) bool {
+	t.Helper()
+	ok, err := exists(OSFS{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+// exportCount returns how many lines of the exports table export `upper`
+func exportCount(t *testing.T, p *Provisioner, upper string) int {
+	t.Helper()
+	lines, err := p.readExports()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for _, line := range lines {
+		if exportPath(line) == upper {
+			count++
+		}
+	}
+	return count
+}
+
+func TestAddDevice(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, p *Provisioner, m *fakeMounter)
+	}{
+		{"fresh", func(t *testing.T, p *Provisioner, m *fakeMounter) {}},
+		{"twice", func(t *testing.T, p *Provisioner, m *fakeMounter) {
+			if err := p.AddDevice(context.Background(), "pi-1", ioutil.Discard); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"after failed mount", func(t *testing.T, p *Provisioner, m *fakeMounter) {
+			m.failMounts = 1
+			if err := p.AddDevice(context.Background(), "pi-1", ioutil.Discard); err == nil {
+				t.Fatal("expected the first run to fail")
+			}
+		}},
+		{"after interrupted copy", func(t *testing.T, p *Provisioner, m *fakeMounter) {
+			// the upper directory exists, but nothing has been customised
+			if err := os.MkdirAll(p.UpperDir("pi-1"), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, m := newTestProvisioner(t)
+			tt.prepare(t, p, m)
+
+			if err := p.AddDevice(context.Background(), "pi-1", ioutil.Discard); err != nil {
+				t.Fatalf("AddDevice: %s", err)
+			}
+
+			upper := p.UpperDir("pi-1")
+			if !pathExists(t, p.TFTPDir("pi-1")) {
+				t.Error("tftp directory is missing")
+			}
+			if got := readFile(t, filepath.Join(upper, "etc", "hostname")); got != "pi-1\n" {
+				t.Errorf("hostname = %q", got)
+			}
+			if got := readFile(t, filepath.Join(upper, "boot", "disabled", "cmdline.txt")); !strings.Contains(got, "nfsroot=10.0.0.1:"+upper+",") {
+				t.Errorf("cmdline = %q", got)
+			}
+			if got := exportCount(t, p, upper); got != 1 {
+				t.Errorf("overlay is exported %d times", got)
+			}
+			if m.mounts[upper] != p.Config.TemplateLower {
+				t.Errorf("overlay is mounted on %q", m.mounts[upper])
+			}
+		})
+	}
+}
+
+func TestAddDeviceInvalidHostname(t *testing.T) {
+	p, _ := newTestProvisioner(t)
+	p.Config.SnapshotDir = filepath.Join(p.Config.NFSRoot, "snapshots")
+
+	for _, hostname := range []string{"", "../etc", "pi 1", "-pi", "pi-1-work", "snapshots"} {
+		err := p.AddDevice(context.Background(), hostname, ioutil.Discard)
+		if !errors.Is(err, ErrInvalidHostname) {
+			t.Errorf("AddDevice(%q) = %v, want %v", hostname, err, ErrInvalidHostname)
+		}
+	}
+}
+
+func TestCheckHostname(t *testing.T) {
+	p, _ := newTestProvisioner(t)
+	image := filepath.Join(p.Config.NFSRoot, "raspios")
+
+	tests := []struct {
+		hostname string
+		reserved []string
+		wantErr  bool
+	}{
+		{"pi-1", nil, false},
+		{"pi-1", []string{image}, false},
+		{"raspios", []string{image}, true},
+		{"raspios", []string{filepath.Join(image, "boot")}, true},
+		{"raspios", []string{p.Config.NFSRoot}, true},
+		{"raspios-work", nil, true},
+	}
+
+	for _, tt := range tests {
+		err := p.CheckHostname(tt.hostname, tt.reserved...)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckHostname(%q, %q) = %v, want error: %v", tt.hostname, tt.reserved, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDeleteDevice(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, p *Provisioner)
+	}{
+		{"provisioned", func(t *testing.T, p *Provisioner) {
+			if err := p.AddDevice(context.Background(), "pi-1", ioutil.Discard); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"deployed", func(t *testing.T, p *Provisioner) {
+			if err := p.AddDevice(context.Background(), "pi-1", ioutil.Discard); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.DeployDevice(context.Background(), "pi-1", "", nil, ioutil.Discard); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"twice", func(t *testing.T, p *Provisioner) {
+			if err := p.AddDevice(context.Background(), "pi-1", ioutil.Discard); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.DeleteDevice(context.Background(), "pi-1", ioutil.Discard); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"partially provisioned", func(t *testing.T, p *Provisioner) {
+			// a previous setup only created the tftp directory and export
+			if err := os.MkdirAll(p.TFTPDir("pi-1"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.addExport(p.UpperDir("pi-1")); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"never provisioned", func(t *testing.T, p *Provisioner) {}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, m := newTestProvisioner(t)
+
+			// another device must be left untouched
+			if err := p.AddDevice(context.Background(), "pi-2", ioutil.Discard); err != nil {
+				t.Fatal(err)
+			}
+			tt.prepare(t, p)
+
+			if err := p.DeleteDevice(context.Background(), "pi-1", ioutil.Discard); err != nil {
+				t.Fatalf("DeleteDevice: %s", err)
+			}
+
+			for _, path := range []string{p.TFTPDir("pi-1"), p.UpperDir("pi-1"), p.WorkDir("pi-1")} {
+				if pathExists(t, path) {
+					t.Errorf("%s still exists", path)
+				}
+				if _, ok := m.mounts[path]; ok {
+					t.Errorf("%s is still mounted", path)
+				}
+			}
+			if got := exportCount(t, p, p.UpperDir("pi-1")); got != 0 {
+				t.Errorf("overlay is still exported %d times", got)
+			}
+
+			if !pathExists(t, p.UpperDir("pi-2")) || exportCount(t, p, p.UpperDir("pi-2")) != 1 {
+				t.Error("the other device has been modified")
+			}
+		})
+	}
+}
+
+func TestDeployDevice(t *testing.T) {
+	p, m := newTestProvisioner(t)
+	if err := p.AddDevice(context.Background(), "pi-1", ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	image := filepath.Join(tempDir(t), "image")
+	if err := os.MkdirAll(image, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// deploying twice must give the same result
+	for i := 0; i < 2; i++ {
+		if err := p.DeployDevice(context.Background(), "pi-1", image, nil, ioutil.Discard); err != nil {
+			t.Fatalf("DeployDevice: %s", err)
+		}
+	}
+
+	upper := p.UpperDir("pi-1")
+	if m.mounts[upper] != image {
+		t.Errorf("overlay is mounted on %q, want %q", m.mounts[upper], image)
+	}
+	if m.mounts[p.TFTPDir("pi-1")] != filepath.Join(upper, "boot") {
+		t.Errorf("boot partition is bound from %q", m.mounts[p.TFTPDir("pi-1")])
+	}
+	if !pathExists(t, filepath.Join(upper, "boot", "start4.elf")) {
+		t.Error("boot files are not enabled")
+	}
+}
+
+func TestDeployDeviceSymlink(t *testing.T) {
+	p, m := newTestProvisioner(t)
+	if err := p.AddDevice(context.Background(), "pi-1", ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	// the owner of the device replaced its boot partition by a link to the
+	// host
+	outside := tempDir(t)
+	boot := filepath.Join(p.UpperDir("pi-1"), "boot")
+	if err := os.RemoveAll(boot); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, boot); err != nil {
+		t.Fatal(err)
+	}
+
+	err := p.DeployDevice(context.Background(), "pi-1", "", nil, ioutil.Discard)
+	if !errors.Is(err, ErrSymlink) {
+		t.Errorf("DeployDevice = %v, want %v", err, ErrSymlink)
+	}
+	if _, ok := m.mounts[p.TFTPDir("pi-1")]; ok {
+		t.Error("the host directory is bound as the boot partition")
+	}
+}
+
+func TestDeployDeviceNotProvisioned(t *testing.T) {
+	p, m := newTestProvisioner(t)
+
+	err := p.DeployDevice(context.Background(), "pi-1", "", nil, ioutil.Discard)
+	if !errors.Is(err, ErrNotProvisioned) {
+		t.Fatalf("DeployDevice = %v, want %v", err, ErrNotProvisioned)
+	}
+
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) || stepErr.Hostname != "pi-1" {
+		t.Errorf("DeployDevice = %v, want a step error of pi-1", err)
+	}
+	if len(m.mounts) != 0 {
+		t.Errorf("mounted %v", m.mounts)
+	}
+}
+
+func TestResetDevice(t *testing.T) {
+	p, m := newTestProvisioner(t)
+	if err := p.AddDevice(context.Background(), "pi-1", ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DeployDevice(context.Background(), "pi-1", "", nil, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	upper := p.UpperDir("pi-1")
+	writeFile(t, filepath.Join(upper, "home", "pi", "notes.txt"), "left by the previous user")
+
+	image := filepath.Join(tempDir(t), "image")
+	if err := os.MkdirAll(image, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.ResetDevice(context.Background(), "pi-1", image, ioutil.Discard); err != nil {
+		t.Fatalf("ResetDevice: %s", err)
+	}
+
+	if pathExists(t, filepath.Join(upper, "home", "pi", "notes.txt")) {
+		t.Error("the overlay still holds the files of the previous user")
+	}
+	if got := readFile(t, filepath.Join(upper, "etc", "hostname")); got != "pi-1\n" {
+		t.Errorf("hostname = %q", got)
+	}
+	if hosts := readFile(t, filepath.Join(upper, "etc", "hosts")); strings.Contains(hosts, "raspberrypi") || !strings.Contains(hosts, "127.0.1.1\tpi-1") {
+		t.Errorf("hosts = %q", hosts)
+	}
+	if pathExists(t, filepath.Join(upper, "boot", "start4.elf")) {
+		t.Error("boot files are still enabled")
+	}
+	if m.mounts[upper] != image {
+		t.Errorf("overlay is mounted on %q, want %q", m.mounts[upper], image)
+	}
+	if _, ok := m.mounts[p.TFTPDir("pi-1")]; ok {
+		t.Error("boot partition is still bound")
+	}
+	if got := exportCount(t, p, upper); got != 1 {
+		t.Errorf("overlay is exported %d times", got)
+	}
+}
+
+func TestExports(t *testing.T) {
+	const upper = "/pxe/nfs/pi-1"
+	const line = upper + " *(rw,sync)"
+
+	tests := []struct {
+		name    string
+		initial *string
+		add     bool
+		want    string
+	}{
+		{"add to missing table", nil, true, line + "\n"},
+		{"add to empty table", strPtr(""), true, line + "\n"},
+		{"add after other exports", strPtr("# exports\n/pxe/nfs/pi-2 *(ro)\n"), true,
+			"# exports\n/pxe/nfs/pi-2 *(ro)\n" + line + "\n"},
+		{"add when already exported", strPtr("/pxe/nfs/pi-1/ *(ro)\n"), true, "/pxe/nfs/pi-1/ *(ro)\n"},
+		{"add without trailing newline", strPtr("/pxe/nfs/pi-2 *(ro)"), true, "/pxe/nfs/pi-2 *(ro)\n" + line + "\n"},
+		{"remove the only export", strPtr(line + "\n"), false, ""},
+		{"remove among other exports", strPtr("# exports\n" + line + "\n/pxe/nfs/pi-2 *(ro)\n"), false,
+			"# exports\n/pxe/nfs/pi-2 *(ro)\n"},
+		{"remove a prefix of another export", strPtr("/pxe/nfs/pi-10 *(ro)\n"), false, "/pxe/nfs/pi-10 *(ro)\n"},
+		{"remove from missing table", nil, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newTestProvisioner(t)
+			if tt.initial != nil {
+				writeFile(t, p.Config.ExportsFile, *tt.initial)
+			}
+
+			var err error
+			if tt.add {
+				err = p.addExport(upper)
+			} else {
+				err = p.removeExport(upper)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := ""
+			if pathExists(t, p.Config.ExportsFile) {
+				got = readFile(t, p.Config.ExportsFile)
+			}
+			if got != tt.want {
+				t.Errorf("exports = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
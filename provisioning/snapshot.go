@@ -0,0 +1,215 @@
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// SnapshotPath returns the archive holding the snapshot with the given `name`
+func (p *Provisioner) SnapshotPath(name string) string {
+	return filepath.Join(p.Config.SnapshotDir, name+".tar.zst")
+}
+
+// SnapshotDevice archives the upper layer of the overlay of the device with
+// the given `hostname` into the `archive` file. The overlay is unmounted
+// while it is archived, then mounted again on top of the `lower` layer (an
+// empty `lower` meaning the default template), and its boot partition is
+// exposed again if it was. It returns the size of the archive. Progress is
+// written into `log`.
+func (p *Provisioner) SnapshotDevice(ctx context.Context, hostname, lower, archive string, log io.Writer) (int64, error) {
+	if err := p.validateHostname(hostname); err != nil {
+		return 0, err
+	}
+
+	if lower == "" {
+		lower = p.Config.TemplateLower
+	}
+
+	upper := p.UpperDir(hostname)
+	tftp := p.TFTPDir(hostname)
+	deployed := false
+
+	err := p.runSteps(ctx, hostname, log, []step{
+		{"check provisioning", func() error {
+			ok, err := exists(p.FS, upper)
+			if err == nil && !ok {
+				err = ErrNotProvisioned
+			}
+			return err
+		}},
+		{"unmount boot partition", func() error {
+			var err error
+			if deployed, err = p.Mounter.IsMounted(tftp); err != nil || !deployed {
+				return err
+			}
+			return p.Mounter.Unmount(tftp)
+		}},
+		{"unmount overlay", func() error {
+			return p.unmount(upper)
+		}},
+		{"archive upper overlay", func() error {
+			return p.writeArchive(upper, archive)
+		}},
+	})
+
+	// the overlay is mounted back even if the archive could not be written
+	restore := []step{
+		{"mount overlay on " + lower, func() error {
+			return p.mountOverlay(hostname, lower)
+		}},
+	}
+	if deployed {
+		restore = append(restore, step{"bind boot partition", func() error {
+			if err := checkPath(p.FS, upper, "boot"); err != nil {
+				return err
+			}
+			return p.Mounter.BindMount(filepath.Join(upper, "boot"), tftp)
+		}})
+	}
+	if restoreErr := p.runSteps(context.Background(), hostname, log, restore); err == nil {
+		err = restoreErr
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := p.FS.Lstat(archive)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+// RestoreDevice replaces the upper layer of the overlay of the device with
+// the given `hostname` by the content of the `archive` written by
+// `SnapshotDevice`, possibly for another device. The hostname is customised
+// again, then the overlay is mounted on top of the `lower` layer (an empty
+// `lower` meaning the default template). Like after `ResetDevice`, the boot
+// files are disabled until the device is deployed again. Progress is written
+// into `log`.
+func (p *Provisioner) RestoreDevice(ctx context.Context, hostname, lower, archive string, log io.Writer) error {
+	if err := p.validateHostname(hostname); err != nil {
+		return err
+	}
+
+	if lower == "" {
+		lower = p.Config.TemplateLower
+	}
+
+	upper := p.UpperDir(hostname)
+	tftp := p.TFTPDir(hostname)
+
+	return p.runSteps(ctx, hostname, log, []step{
+		{"check snapshot", func() error {
+			ok, err := exists(p.FS, archive)
+			if err == nil && !ok {
+				err = fmt.Errorf("%s: snapshot not found", archive)
+			}
+			return err
+		}},
+		{"unmount boot partition", func() error {
+			return p.unmount(tftp)
+		}},
+		{"unmount overlay", func() error {
+			return p.unmount(upper)
+		}},
+		{"remove overlay", func() error {
+			if err := p.FS.RemoveAll(upper); err != nil {
+				return err
+			}
+			return p.FS.RemoveAll(p.WorkDir(hostname))
+		}},
+		{"extract snapshot", func() error {
+			if err := p.FS.MkdirAll(upper, 0755); err != nil {
+				return err
+			}
+			f, err := p.FS.Open(archive)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return extractTree(p.FS, f, upper)
+		}},
+		{"disable boot files", func() error {
+			return p.disableBoot(upper)
+		}},
+		{"customise hostname", func() error {
+			return p.customiseHostname(upper, hostname)
+		}},
+		{"write kernel command line", func() error {
+			return p.writeCmdline(upper, hostname)
+		}},
+		{"mount overlay on " + lower, func() error {
+			return p.mountOverlay(hostname, lower)
+		}},
+	})
+}
+
+// DeleteSnapshot removes the `archive` written by `SnapshotDevice`
+func (p *Provisioner) DeleteSnapshot(archive string) error {
+	return p.FS.RemoveAll(archive)
+}
+
+// writeArchive archives the `src` directory into a temporary file which is
+// then renamed to `archive`, so that a failure never leaves a partial archive
+func (p *Provisioner) writeArchive(src, archive string) error {
+	if err := p.FS.MkdirAll(filepath.Dir(archive), 0755); err != nil {
+		return err
+	}
+
+	tmp := archive + ".tmp"
+	f, err := p.FS.Create(tmp, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = archiveTree(p.FS, src, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		p.FS.RemoveAll(tmp)
+		return err
+	}
+
+	return p.FS.Rename(tmp, archive)
+}
+
+// disableBoot moves the boot files back into the `disabled` directory of the
+// boot partition, undoing `enableBoot`
+func (p *Provisioner) disableBoot(upper string) error {
+	boot := filepath.Join(upper, "boot")
+	disabled := filepath.Join(boot, "disabled")
+
+	if err := checkPath(p.FS, upper, filepath.Join("boot", "disabled")); err != nil {
+		return err
+	}
+
+	if ok, err := exists(p.FS, boot); err != nil || !ok {
+		return err
+	}
+
+	if err := p.FS.MkdirAll(disabled, 0755); err != nil {
+		return err
+	}
+
+	entries, err := p.FS.ReadDir(boot)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Name() == "disabled" {
+			continue
+		}
+		if err := p.FS.Rename(filepath.Join(boot, entry.Name()), filepath.Join(disabled, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
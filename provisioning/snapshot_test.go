@@ -0,0 +1,61 @@
+package provisioning
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestoreDeviceSymlink(t *testing.T) {
+	tests := []struct {
+		name    string
+		link    string
+		planted string
+	}{
+		{"boot", "boot", "start4.elf"},
+		{"etc", "etc", "hostname"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newTestProvisioner(t)
+			if err := p.AddDevice(context.Background(), "pi-1", ioutil.Discard); err != nil {
+				t.Fatal(err)
+			}
+
+			outside := tempDir(t)
+			writeFile(t, filepath.Join(outside, tt.planted), "host file")
+
+			archive := filepath.Join(p.Config.SnapshotDir, "evil.tar.zst")
+			content := writeTestArchive(t, []testEntry{
+				{name: tt.link, typeflag: tar.TypeSymlink, linkname: outside},
+			})
+			if err := os.MkdirAll(p.Config.SnapshotDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(archive, content.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := p.RestoreDevice(context.Background(), "pi-1", "", archive, ioutil.Discard)
+			if !errors.Is(err, ErrSymlink) {
+				t.Errorf("RestoreDevice = %v, want %v", err, ErrSymlink)
+			}
+
+			entries, err := ioutil.ReadDir(outside)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || entries[0].Name() != tt.planted {
+				t.Errorf("the host directory has been modified: %d entries", len(entries))
+			}
+			if got := readFile(t, filepath.Join(outside, tt.planted)); got != "host file" {
+				t.Errorf("%s = %q", tt.planted, got)
+			}
+		})
+	}
+}
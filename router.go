@@ -30,21 +30,41 @@ func createRESTEndpoints(s server) {
 
 	// controllers
     authentication := controllers.AuthenticationController{DB: s.db, Cfg: s.cfg}
-	user := controllers.UserController{DB: s.db, Cfg: s.cfg}
-	device := controllers.DeviceController{DB: s.db}
-	provisioner := controllers.ProvisionerController{DB: s.db}
-	admin := controllers.AdminController{DB: s.db, Cfg: s.cfg}
+	user := controllers.UserController{DB: s.db, Cfg: s.cfg, Providers: s.providers, Provisioner: s.provisioner, Queue: s.queue}
+	device := controllers.DeviceController{DB: s.db, Cfg: s.cfg, Providers: s.providers, Jobs: s.jobs}
+	provisioner := controllers.ProvisionerController{DB: s.db, Cfg: s.cfg, Providers: s.providers, Jobs: s.jobs, Provisioner: s.provisioner, Queue: s.queue}
+	admin := controllers.AdminController{DB: s.db, Cfg: s.cfg, Providers: s.providers, Syncer: s.syncer, Jobs: s.jobs, Provisioner: s.provisioner, Queue: s.queue}
+	job := controllers.JobController{DB: s.db, Jobs: s.jobs}
+	image := controllers.ImageController{DB: s.db, Provisioner: s.provisioner}
+	reservation := controllers.ReservationController{DB: s.db, Cfg: s.cfg, Queue: s.queue}
+	queue := controllers.QueueController{DB: s.db, Cfg: s.cfg, Queue: s.queue}
+	pool := controllers.PoolController{DB: s.db, Cfg: s.cfg, Providers: s.providers, Jobs: s.jobs, Provisioner: s.provisioner, Queue: s.queue}
+	snapshot := controllers.SnapshotController{DB: s.db, Cfg: s.cfg, Providers: s.providers, Jobs: s.jobs, Provisioner: s.provisioner}
 
 	// groups
 	userGr := s.e.Group("/user")
 	deviceGr := s.e.Group("/device")
 	adminGr := s.e.Group("/admin")
+	jobGr := s.e.Group("/job")
+	imageGr := s.e.Group("/image")
+	snapshotGr := s.e.Group("/snapshot")
+	reservationGr := s.e.Group("/reservation")
+	queueGr := s.e.Group("/queue")
+	poolGr := s.e.Group("/pool")
+	batchGr := s.e.Group("/batch")
 
 	// jwt protection
 	secret := s.cfg.Section("security").Key("jwtsecret").String()
 	userGr.Use(middleware.JWT([]byte(secret)))
 	deviceGr.Use(middleware.JWT([]byte(secret)))
 	adminGr.Use(middleware.JWT([]byte(secret)))
+	jobGr.Use(middleware.JWT([]byte(secret)))
+	imageGr.Use(middleware.JWT([]byte(secret)))
+	snapshotGr.Use(middleware.JWT([]byte(secret)))
+	reservationGr.Use(middleware.JWT([]byte(secret)))
+	queueGr.Use(middleware.JWT([]byte(secret)))
+	poolGr.Use(middleware.JWT([]byte(secret)))
+	batchGr.Use(middleware.JWT([]byte(secret)))
 
 	s.e.GET("/login", authentication.Login)
 
@@ -52,21 +72,97 @@ func createRESTEndpoints(s server) {
 	userGr.GET("/me", user.GetMe)
 	userGr.PUT("/me", user.UpdateMe)
 	userGr.DELETE("/me", user.DeleteMe)
+	userGr.GET("/me/quota", user.GetQuota)
+	userGr.GET("/me/keys", user.ListKey)
+	userGr.POST("/me/keys", user.AddKey)
+	userGr.GET("/me/keys/:id", user.GetKey)
+	userGr.PUT("/me/keys/:id", user.UpdateKey)
+	userGr.DELETE("/me/keys/:id", user.DeleteKey)
+	userGr.GET("/me/notifications", user.ListNotification)
+	userGr.DELETE("/me/notifications/:id", user.DeleteNotification)
 
 	// device endpoints
 	deviceGr.GET("", device.ListDevice)
+	deviceGr.POST("/acquire", provisioner.AcquireByCriteria)
 	deviceGr.GET("/:id", device.Get)
 	deviceGr.POST("/:id/on", device.PowerOn)
 	deviceGr.POST("/:id/off", device.PowerOff)
+	deviceGr.POST("/:id/reboot", device.Reboot)
+	deviceGr.GET("/:id/logs", device.Logs)
 	deviceGr.POST("/:id/acquire", provisioner.Acquire)
 	deviceGr.POST("/:id/release", provisioner.Release)
+	deviceGr.POST("/:id/renew", provisioner.Renew)
 	deviceGr.POST("/:id/deploy", provisioner.Deploy)
+	deviceGr.POST("/:id/snapshot", snapshot.CreateSnapshot)
+	deviceGr.GET("/:id/snapshot", snapshot.ListDeviceSnapshot)
+
+	// job endpoints
+	jobGr.GET("", job.ListJob)
+	jobGr.GET("/:id", job.Get)
+	jobGr.POST("/:id/cancel", job.Cancel)
+
+	// image endpoints
+	imageGr.GET("", image.ListImage)
+
+	// snapshot endpoints
+	snapshotGr.GET("", snapshot.ListSnapshot)
+	snapshotGr.GET("/:id", snapshot.Get)
+	snapshotGr.POST("/:id/restore", snapshot.Restore)
+	snapshotGr.DELETE("/:id", snapshot.Delete)
+
+	// reservation endpoints
+	reservationGr.GET("", reservation.ListReservation)
+	reservationGr.POST("", reservation.CreateReservation)
+	reservationGr.GET("/:id", reservation.Get)
+	reservationGr.DELETE("/:id", reservation.Cancel)
+
+	// queue endpoints
+	queueGr.GET("", queue.ListQueue)
+	queueGr.POST("", queue.Enqueue)
+	queueGr.GET("/:id", queue.Get)
+	queueGr.DELETE("/:id", queue.Cancel)
+
+	// pool endpoints
+	poolGr.GET("", pool.ListPool)
+	poolGr.GET("/:name", pool.Get)
+	poolGr.GET("/:name/device", pool.ListPoolDevice)
+	poolGr.POST("/:name/on", pool.PowerOn)
+	poolGr.POST("/:name/off", pool.PowerOff)
+	poolGr.POST("/:name/deploy", pool.Deploy)
+	poolGr.POST("/:name/acquire", pool.Acquire)
+	poolGr.POST("/:name/release", pool.Release)
+
+	// batch endpoints
+	batchGr.GET("", pool.ListBatch)
+	batchGr.GET("/:id", pool.GetBatch)
 
 	// admin endpoints
 	adminGr.POST("/device", admin.CreateDevice)
+	adminGr.GET("/device/discover", admin.DiscoverDevice)
+	adminGr.POST("/device/import", admin.ImportDevice)
+	adminGr.PUT("/device/:id", admin.UpdateDeviceLocation)
+	adminGr.PUT("/device/:id/labels", admin.UpdateDeviceLabels)
+	adminGr.POST("/device/:id/release", admin.ForceReleaseDevice)
+	adminGr.POST("/device/:id/transfer", admin.TransferDevice)
 	adminGr.DELETE("/device", admin.DeleteDevice)
+	adminGr.POST("/pool", pool.CreatePool)
+	adminGr.DELETE("/pool/:name", pool.DeletePool)
+	adminGr.POST("/pool/:name/device", pool.AddPoolDevice)
+	adminGr.DELETE("/pool/:name/device/:id", pool.RemovePoolDevice)
+	adminGr.GET("/provider", admin.ListProvider)
+	adminGr.POST("/provider", admin.CreateProvider)
+	adminGr.DELETE("/provider/:name", admin.DeleteProvider)
+	adminGr.POST("/image", image.CreateImage)
+	adminGr.GET("/image", image.ListAllImage)
+	adminGr.GET("/image/:id", image.GetImage)
+	adminGr.DELETE("/image/:id", image.RetireImage)
+	adminGr.GET("/sync", admin.GetSyncReport)
+	adminGr.POST("/sync", admin.Sync)
 	adminGr.POST("/user", admin.CreateUser)
 	adminGr.GET("/user", admin.ListUser)
 	adminGr.DELETE("/user/:id", admin.DeleteUser)
 	adminGr.POST("/user/:id/expiration", admin.UpdateUserExpiration)
+	adminGr.GET("/user/:id/device", admin.ListUserDevice)
+	adminGr.GET("/user/:id/quota", admin.GetUserQuota)
+	adminGr.PUT("/user/:id/quota", admin.UpdateUserQuota)
 }
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"strconv"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/xiorcale/rubus-api/models"
+)
+
+// Discover lists every device reported by the provider with the given
+// `name`, and tells which of them are already registered in the database
+func Discover(db *pg.DB, providers *ProviderRegistry, name string) ([]models.DiscoveredDevice, *models.JSONError) {
+	provider, jsonErr := providers.Get(name)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	remotes, jsonErr := provider.GetAllDevices()
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	devices, jsonErr := models.GetDevicesByProvider(db, name)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	discovered := make([]models.DiscoveredDevice, len(*remotes))
+	for i, remote := range *remotes {
+		discovered[i] = models.DiscoveredDevice{
+			Provider:   name,
+			Port:       remote.Port,
+			Hostname:   remote.Hostname,
+			PowerState: remote.PowerState,
+		}
+	}
+
+	index := indexRemotes(*remotes)
+	for _, device := range *devices {
+		if i, ok := findRemote(index, *remotes, &device); ok {
+			id := device.ID
+			discovered[i].Registered = true
+			discovered[i].DeviceID = &id
+		}
+	}
+
+	return discovered, nil
+}
+
+// indexRemotes indexes the devices reported by a provider by the address
+// they report, or by their port when they report none
+func indexRemotes(remotes []models.Device) map[string]int {
+	index := map[string]int{}
+	for i := range remotes {
+		index[remotes[i].ProviderAddress()] = i
+	}
+
+	return index
+}
+
+// findRemote returns the position of the device reported by the provider
+// which matches the registered `Device`: the one reporting its address, or
+// its port when the provider reports no address
+func findRemote(index map[string]int, remotes []models.Device, device *models.Device) (int, bool) {
+	if i, ok := index[device.ProviderAddress()]; ok {
+		return i, true
+	}
+
+	i, ok := index[strconv.FormatInt(device.Port, 10)]
+	if ok && remotes[i].Address == "" {
+		return i, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/xiorcale/rubus-api/models"
+	"github.com/xiorcale/rubus-api/provisioning"
+)
+
+// expiredNote explains why the devices of an expired `User` are released
+const expiredNote = "user account has expired."
+
+// UserExpirer periodically releases every `Device` owned by the users whose
+// `Expiration` has passed, and cancels their pending requests. The devices are
+// wiped before being released if the `WipePolicy` is `always`. Otherwise, the
+// released devices are shut down if `PowerOff` is true. The released devices
+// are granted to the users waiting in the `Queue`.
+type UserExpirer struct {
+	DB          *pg.DB
+	Providers   *ProviderRegistry
+	Jobs        *JobRunner
+	Provisioner *provisioning.Provisioner
+	Queue       *WaitQueue
+	Interval    time.Duration
+	PowerOff    bool
+	WipePolicy  WipePolicy
+}
+
+// NewUserExpirer returns a `UserExpirer` which runs every `interval` once
+// started
+func NewUserExpirer(db *pg.DB, providers *ProviderRegistry, jobs *JobRunner, p *provisioning.Provisioner, queue *WaitQueue, interval time.Duration, powerOff bool, wipePolicy WipePolicy) *UserExpirer {
+	return &UserExpirer{
+		DB:          db,
+		Providers:   providers,
+		Jobs:        jobs,
+		Provisioner: p,
+		Queue:       queue,
+		Interval:    interval,
+		PowerOff:    powerOff,
+		WipePolicy:  wipePolicy,
+	}
+}
+
+// Start runs the expirer in the background. A non positive interval disables
+// it.
+func (e *UserExpirer) Start() {
+	if e.Interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(e.Interval)
+		defer ticker.Stop()
+
+		for {
+			e.Expire()
+			<-ticker.C
+		}
+	}()
+}
+
+// Expire releases the devices of every expired `User`
+func (e *UserExpirer) Expire() {
+	users, jsonErr := models.GetExpiredUsers(e.DB)
+	if jsonErr != nil {
+		log.Printf("Expire users: %s", jsonErr.Error)
+		return
+	}
+
+	released := []models.Device{}
+	for _, user := range *users {
+		devices, jsonErr := e.expire(&user)
+		if jsonErr != nil {
+			log.Printf("Expire user %d: %s", user.ID, jsonErr.Error)
+		}
+		released = append(released, devices...)
+	}
+
+	// the released devices are granted to the users waiting for them
+	if len(released) > 0 {
+		e.Queue.Process()
+	}
+}
+
+// expire releases the devices of the expired `User`, or submits their wipe,
+// and cancels their requests. It returns the released devices.
+func (e *UserExpirer) expire(user *models.User) ([]models.Device, *models.JSONError) {
+	if jsonErr := models.CancelUserRequests(e.DB, user.ID); jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	devices, jsonErr := models.GetDevicesByOwner(e.DB, user.ID)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	released := []models.Device{}
+	for i := range *devices {
+		device := &(*devices)[i]
+
+		// the device is released by its wipe, once it is over
+		if e.WipePolicy == EnumWipeAlways {
+			if e.Jobs.Busy(device.ID) {
+				continue
+			}
+			if _, jsonErr := SubmitWipe(e.Jobs, e.Providers, e.Provisioner, device, models.EnumLeaseRevoked, expiredNote, user.ID); jsonErr != nil {
+				log.Printf("Wipe device %d of user %d: %s", device.ID, user.ID, jsonErr.Error)
+			}
+			continue
+		}
+
+		// the device is shut down while the user still owns it, so that no
+		// other user can acquire it in the meantime
+		if e.PowerOff {
+			powerOffDevice(e.DB, e.Providers, device)
+		}
+
+		if jsonErr := models.RevokeDevice(e.DB, device, expiredNote); jsonErr != nil {
+			log.Printf("Release device %d of user %d: %s", device.ID, user.ID, jsonErr.Error)
+			continue
+		}
+		released = append(released, *device)
+	}
+
+	return released, nil
+}
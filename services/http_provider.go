@@ -0,0 +1,259 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xiorcale/rubus-api/models"
+	"gopkg.in/ini.v1"
+)
+
+// HTTPProviderConfig contains the settings used to reach a provider
+// implementing the Rubus Provider API specification
+type HTTPProviderConfig struct {
+	BaseURL    string
+	Timeout    time.Duration
+	CACert     string
+	ClientCert string
+	ClientKey  string
+	Token      string
+}
+
+// ReadHTTPProviderConfig returns the `HTTPProviderConfig` described by the
+// given configuration section. Missing keys are looked up in the parent
+// section, so `[provider.xxx]` sections inherit the `[provider]` settings.
+func ReadHTTPProviderConfig(section *ini.Section) HTTPProviderConfig {
+	return HTTPProviderConfig{
+		BaseURL:    section.Key("base_url").MustString("http://rubus_provider:1080"),
+		Timeout:    section.Key("timeout").MustDuration(30 * time.Second),
+		CACert:     section.Key("ca_cert").String(),
+		ClientCert: section.Key("client_cert").String(),
+		ClientKey:  section.Key("client_key").String(),
+		Token:      section.Key("token").String(),
+	}
+}
+
+// remoteDevice is the `Device` as described by the Rubus Provider API
+// specification, where the `id` is the switch port
+type remoteDevice struct {
+	ID         int64  `json:"id"`
+	Hostname   string `json:"hostname"`
+	IsTurnedOn bool   `json:"isTurnedOn"`
+}
+
+func (d *remoteDevice) toDevice() models.Device {
+	device := models.Device{
+		Hostname: d.Hostname,
+		Port:     d.ID,
+	}
+
+	if d.IsTurnedOn {
+		device.SetPowerState(models.EnumPowerOn)
+	} else {
+		device.SetPowerState(models.EnumPowerOff)
+	}
+
+	return device
+}
+
+// HTTPProvider is a `Provider` which talks to a back end implementing the
+// Rubus Provider API specification (see `providers/swagger.yaml`)
+type HTTPProvider struct {
+	BaseURL string
+	token   string
+	client  *http.Client
+}
+
+// NewHTTPProvider returns an `HTTPProvider` configured with the given `cfg`
+func NewHTTPProvider(cfg HTTPProviderConfig) (*HTTPProvider, error) {
+	if cfg.BaseURL == "" {
+		return nil, errors.New("provider base URL is required")
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if cfg.CACert != "" {
+		pem, err := ioutil.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("provider CA certificate is not valid")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &HTTPProvider{
+		BaseURL: strings.TrimSuffix(cfg.BaseURL, "/"),
+		token:   cfg.Token,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+		},
+	}, nil
+}
+
+func (p *HTTPProvider) request(method, path string, body io.Reader) (*http.Response, *models.JSONError) {
+	req, err := http.NewRequest(method, p.BaseURL+path, body)
+	if err != nil {
+		return nil, models.NewInternalServerError()
+	}
+
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil, &models.JSONError{
+				Status: http.StatusGatewayTimeout,
+				Error:  "provider did not answer in time",
+			}
+		}
+		return nil, &models.JSONError{
+			Status: http.StatusBadGateway,
+			Error:  "provider is unreachable",
+		}
+	}
+
+	return res, nil
+}
+
+// GetDevice returns the `Device` on the given `port`
+func (p *HTTPProvider) GetDevice(port string) (*models.Device, *models.JSONError) {
+	res, jsonErr := p.request("GET", "/device/"+port, nil)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, &models.JSONError{
+			Status: http.StatusNotFound,
+			Error:  "device not found",
+		}
+	}
+
+	if jsonErr := checkStatus(res); jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	remote := remoteDevice{}
+	if err := json.NewDecoder(res.Body).Decode(&remote); err != nil {
+		return nil, models.NewInternalServerError()
+	}
+
+	device := remote.toDevice()
+	return &device, nil
+}
+
+// GetAllDevices returns all the `Device` exposed by the provider
+func (p *HTTPProvider) GetAllDevices() (*[]models.Device, *models.JSONError) {
+	res, jsonErr := p.request("GET", "/device", nil)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+	defer res.Body.Close()
+
+	if jsonErr := checkStatus(res); jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	remotes := []remoteDevice{}
+	if err := json.NewDecoder(res.Body).Decode(&remotes); err != nil {
+		return nil, models.NewInternalServerError()
+	}
+
+	devices := make([]models.Device, len(remotes))
+	for i := range remotes {
+		devices[i] = remotes[i].toDevice()
+	}
+
+	return &devices, nil
+}
+
+// PowerOn boots the `Device` on the given `port`
+func (p *HTTPProvider) PowerOn(port string) *models.JSONError {
+	return p.power(port, "on")
+}
+
+// PowerOff shuts down the `Device` on the given `port`
+func (p *HTTPProvider) PowerOff(port string) *models.JSONError {
+	return p.power(port, "off")
+}
+
+// Cycle shuts down the `Device` on the given `port`, waits for `delay` so that
+// the PoE port stays down long enough, and boots it again
+func (p *HTTPProvider) Cycle(port string, delay time.Duration) *models.JSONError {
+	if jsonErr := p.PowerOff(port); jsonErr != nil {
+		return jsonErr
+	}
+
+	time.Sleep(delay)
+
+	return p.PowerOn(port)
+}
+
+func (p *HTTPProvider) power(port, state string) *models.JSONError {
+	res, jsonErr := p.request("POST", "/device/"+port+"/"+state, nil)
+	if jsonErr != nil {
+		return jsonErr
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return &models.JSONError{
+			Status: http.StatusNotFound,
+			Error:  "device not found",
+		}
+	}
+
+	return checkStatus(res)
+}
+
+// checkStatus returns a Bad Gateway `JSONError` holding the body of the
+// response if the provider did not answer with a 2xx status
+func checkStatus(res *http.Response) *models.JSONError {
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+	return &models.JSONError{
+		Status: http.StatusBadGateway,
+		Error:  fmt.Sprintf("provider answered %d: %s", res.StatusCode, strings.TrimSpace(string(body))),
+	}
+}
+
+// Capabilities returns the operations described by the Rubus Provider API
+// specification
+func (p *HTTPProvider) Capabilities() Capabilities {
+	return Capabilities{
+		Discovery: true,
+		PowerOn:   true,
+		PowerOff:  true,
+		Cycle:     true,
+	}
+}
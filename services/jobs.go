@@ -0,0 +1,219 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/xiorcale/rubus-api/models"
+)
+
+// JobTask is the work performed by a `Job`. Everything written to `output` is
+// saved along with the job, and the task should stop as soon as `ctx` is
+// canceled.
+type JobTask func(ctx context.Context, output io.Writer) error
+
+// jobOutput is the output of a running `Job`, which can be read while the job
+// is still writing into it
+type jobOutput struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (o *jobOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.buf.Write(p)
+}
+
+func (o *jobOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.buf.String()
+}
+
+type runningJob struct {
+	deviceID *int64
+	cancel   context.CancelFunc
+	output   *jobOutput
+}
+
+// JobRunner runs the `Job` in the background and keeps their status up to
+// date in the database
+type JobRunner struct {
+	DB *pg.DB
+	// DeviceFree is called, if set, whenever a `Job` running on a `Device`
+	// ends, so that the device can be used again
+	DeviceFree func(deviceID int64)
+
+	mu      sync.Mutex
+	running map[int64]runningJob
+}
+
+// NewJobRunner returns a `JobRunner` saving the jobs into the given `db`
+func NewJobRunner(db *pg.DB) *JobRunner {
+	return &JobRunner{
+		DB:      db,
+		running: map[int64]runningJob{},
+	}
+}
+
+// Submit saves the `Job` as pending and runs the `task` in the background.
+// Only one job can run on a `Device` at a time.
+func (r *JobRunner) Submit(job *models.Job, task JobTask) *models.JSONError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if job.DeviceID != nil && r.busy(*job.DeviceID) {
+		return &models.JSONError{
+			Status: http.StatusConflict,
+			Error:  "a job is already running on this device.",
+		}
+	}
+
+	job.Status = models.EnumJobPending
+	job.CreatedAt = time.Now()
+
+	if jsonErr := models.AddJob(r.DB, job); jsonErr != nil {
+		return jsonErr
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	output := &jobOutput{}
+	r.running[job.ID] = runningJob{deviceID: job.DeviceID, cancel: cancel, output: output}
+
+	// work on a copy, so that the caller can safely read the submitted job
+	running := *job
+	go r.run(ctx, &running, task, output)
+
+	return nil
+}
+
+// Record saves a `Job` whose `task` has already been performed outside of the
+// runner, along with its `output` and resulting error
+func (r *JobRunner) Record(job *models.Job, output string, err error) *models.JSONError {
+	job.CreatedAt = time.Now()
+	job.StartedAt = job.CreatedAt
+	job.EndedAt = job.CreatedAt
+	job.Output = output
+	finish(job, err)
+
+	return models.AddJob(r.DB, job)
+}
+
+// Cancel stops the `Job` with the given `id` if it is still running
+func (r *JobRunner) Cancel(id int64) *models.JSONError {
+	r.mu.Lock()
+	job, ok := r.running[id]
+	r.mu.Unlock()
+
+	if !ok {
+		return &models.JSONError{
+			Status: http.StatusConflict,
+			Error:  "job is not running.",
+		}
+	}
+
+	job.cancel()
+	return nil
+}
+
+// Output returns the output written so far by the `Job` with the given `id`.
+// It returns false if the job is not running.
+func (r *JobRunner) Output(id int64) (string, bool) {
+	r.mu.Lock()
+	job, ok := r.running[id]
+	r.mu.Unlock()
+
+	if !ok {
+		return "", false
+	}
+
+	return job.output.String(), true
+}
+
+// Busy returns true if a `Job` is running on the `Device` with the given
+// `deviceID`
+func (r *JobRunner) Busy(deviceID int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.busy(deviceID)
+}
+
+func (r *JobRunner) busy(deviceID int64) bool {
+	for _, job := range r.running {
+		if job.deviceID != nil && *job.deviceID == deviceID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *JobRunner) run(ctx context.Context, job *models.Job, task JobTask, output *jobOutput) {
+	defer func() {
+		r.mu.Lock()
+		if rj, ok := r.running[job.ID]; ok {
+			rj.cancel()
+		}
+		delete(r.running, job.ID)
+		r.mu.Unlock()
+
+		if job.DeviceID != nil && r.DeviceFree != nil {
+			r.DeviceFree(*job.DeviceID)
+		}
+	}()
+
+	job.Status = models.EnumJobRunning
+	job.StartedAt = time.Now()
+	if jsonErr := models.UpdateJob(r.DB, job); jsonErr != nil {
+		log.Printf("Job %d: %s", job.ID, jsonErr.Error)
+	}
+
+	err := task(ctx, output)
+
+	job.EndedAt = time.Now()
+	job.Output = output.String()
+
+	if ctx.Err() == context.Canceled {
+		job.Status = models.EnumJobCanceled
+		job.Error = "job has been canceled."
+	} else {
+		finish(job, err)
+	}
+
+	if jsonErr := models.UpdateJob(r.DB, job); jsonErr != nil {
+		log.Printf("Job %d: %s", job.ID, jsonErr.Error)
+	}
+}
+
+// finish sets the status and exit code of the `Job` according to the error
+// returned by its task
+func finish(job *models.Job, err error) {
+	exitCode := 0
+	exitErr := &exec.ExitError{}
+
+	switch {
+	case err == nil:
+		job.Status = models.EnumJobSucceeded
+		job.ExitCode = &exitCode
+	case errors.As(err, &exitErr):
+		exitCode = exitErr.ExitCode()
+		job.Status = models.EnumJobFailed
+		job.ExitCode = &exitCode
+		job.Error = err.Error()
+	default:
+		job.Status = models.EnumJobFailed
+		job.Error = err.Error()
+	}
+}
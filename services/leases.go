@@ -0,0 +1,158 @@
+package services
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/xiorcale/rubus-api/models"
+	"github.com/xiorcale/rubus-api/provisioning"
+	"gopkg.in/ini.v1"
+)
+
+// ExpiryAction is what happens to a `Device` whose `Lease` expired
+type ExpiryAction string
+
+// Values for `ExpiryAction` enum
+const (
+	EnumExpiryRelease  ExpiryAction = "release"
+	EnumExpiryPowerOff ExpiryAction = "poweroff"
+	EnumExpiryWipe     ExpiryAction = "wipe"
+)
+
+// WipePolicy is an enum which specify whether a released `Device` is wiped
+type WipePolicy string
+
+// Values for `WipePolicy` enum
+const (
+	EnumWipeNever    WipePolicy = "never"
+	EnumWipeOptional WipePolicy = "optional"
+	EnumWipeAlways   WipePolicy = "always"
+)
+
+// ReadWipePolicy returns the `wipe_on_release` policy described by the
+// `[provisioning]` section of the configuration
+func ReadWipePolicy(cfg *ini.File) WipePolicy {
+	return WipePolicy(cfg.Section("provisioning").Key("wipe_on_release").In(string(EnumWipeOptional), []string{
+		string(EnumWipeNever),
+		string(EnumWipeOptional),
+		string(EnumWipeAlways),
+	}))
+}
+
+// Wipe tells if a released `Device` is wiped according to the policy, given
+// whether the wipe has been `requested`
+func (p WipePolicy) Wipe(requested bool) (bool, *models.JSONError) {
+	switch p {
+	case EnumWipeAlways:
+		return true, nil
+	case EnumWipeNever:
+		if requested {
+			return false, &models.JSONError{
+				Status: http.StatusBadRequest,
+				Error:  "wiping devices on release is disabled.",
+			}
+		}
+		return false, nil
+	default:
+		return requested, nil
+	}
+}
+
+// LeaseReaper periodically releases the devices whose `Lease` expired, and
+// then shuts them down or wipes them according to its `Action`. The devices
+// are always wiped if the `WipePolicy` is `always`. A wiped device is only
+// released once its wipe is over. The released devices are granted to the
+// users waiting in the `Queue`.
+type LeaseReaper struct {
+	DB          *pg.DB
+	Providers   *ProviderRegistry
+	Jobs        *JobRunner
+	Provisioner *provisioning.Provisioner
+	Queue       *WaitQueue
+	Interval    time.Duration
+	Action      ExpiryAction
+	WipePolicy  WipePolicy
+}
+
+// NewLeaseReaper returns a `LeaseReaper` which runs every `interval` once
+// started
+func NewLeaseReaper(db *pg.DB, providers *ProviderRegistry, jobs *JobRunner, p *provisioning.Provisioner, queue *WaitQueue, interval time.Duration, action ExpiryAction, wipePolicy WipePolicy) *LeaseReaper {
+	return &LeaseReaper{
+		DB:          db,
+		Providers:   providers,
+		Jobs:        jobs,
+		Provisioner: p,
+		Queue:       queue,
+		Interval:    interval,
+		Action:      action,
+		WipePolicy:  wipePolicy,
+	}
+}
+
+// Start runs the reaper in the background. A non positive interval disables
+// it.
+func (r *LeaseReaper) Start() {
+	if r.Interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+
+		for {
+			r.Reap()
+			<-ticker.C
+		}
+	}()
+}
+
+// Reap releases every `Device` whose `Lease` expired
+func (r *LeaseReaper) Reap() {
+	leases, jsonErr := models.GetExpiredLeases(r.DB)
+	if jsonErr != nil {
+		log.Printf("Reap leases: %s", jsonErr.Error)
+		return
+	}
+
+	for _, lease := range *leases {
+		if jsonErr := r.expire(&lease); jsonErr != nil {
+			log.Printf("Reap lease %d of device %d: %s", lease.ID, lease.DeviceID, jsonErr.Error)
+		}
+	}
+
+	if len(*leases) > 0 {
+		r.Queue.Process()
+	}
+}
+
+func (r *LeaseReaper) expire(lease *models.Lease) *models.JSONError {
+	device, jsonErr := models.GetDevice(r.DB, lease.DeviceID)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	// the lease may have been renewed since it was read
+	if device.LeaseExpiresAt == nil || time.Now().Before(*device.LeaseExpiresAt) {
+		return nil
+	}
+
+	// the device is released by its wipe, once it is over
+	if r.Action == EnumExpiryWipe || r.WipePolicy == EnumWipeAlways {
+		if r.Jobs.Busy(device.ID) {
+			return nil
+		}
+		_, jsonErr := SubmitWipe(r.Jobs, r.Providers, r.Provisioner, device, models.EnumLeaseExpired, "", lease.UserID)
+		return jsonErr
+	}
+
+	// the device is shut down while the user still owns it, so that no other
+	// user can acquire it in the meantime
+	if r.Action == EnumExpiryPowerOff {
+		powerOffDevice(r.DB, r.Providers, device)
+	}
+
+	return models.ReleaseDevice(r.DB, device, models.EnumLeaseExpired)
+}
@@ -0,0 +1,24 @@
+package services
+
+import (
+	"log"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/xiorcale/rubus-api/models"
+)
+
+// Notify sends a `Notification` about the `Device` with the given `deviceID`
+// to the `User` with the given `userID`. The notification being a side
+// effect, a failure is only logged.
+func Notify(db *pg.DB, userID int64, notificationType models.NotificationType, deviceID int64, message string) {
+	notification := &models.Notification{
+		UserID:   userID,
+		Type:     notificationType,
+		DeviceID: &deviceID,
+		Message:  message,
+	}
+
+	if jsonErr := models.AddNotification(db, notification); jsonErr != nil {
+		log.Printf("Notify user %d: %s", userID, jsonErr.Error)
+	}
+}
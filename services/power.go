@@ -0,0 +1,81 @@
+package services
+
+import (
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/xiorcale/rubus-api/models"
+)
+
+// PowerOn boots the `Device` through its provider, and stores the power state
+// reported by the provider once the operation is done
+func PowerOn(db *pg.DB, providers *ProviderRegistry, device *models.Device) *models.JSONError {
+	provider, jsonErr := providers.ForDevice(device)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	if jsonErr := provider.PowerOn(device.ProviderAddress()); jsonErr != nil {
+		models.SetDevicePower(db, device, models.EnumPowerError)
+		return jsonErr
+	}
+
+	state := ReportedPowerState(provider, device)
+	if state == models.EnumPowerOn && device.PowerState != models.EnumPowerOn {
+		// the device has just been turned on, it still needs to boot
+		state = models.EnumPowerBooting
+	}
+
+	return models.SetDevicePower(db, device, state)
+}
+
+// PowerOff shuts down the `Device` through its provider, and stores the power
+// state reported by the provider once the operation is done
+func PowerOff(db *pg.DB, providers *ProviderRegistry, device *models.Device) *models.JSONError {
+	provider, jsonErr := providers.ForDevice(device)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	if jsonErr := provider.PowerOff(device.ProviderAddress()); jsonErr != nil {
+		models.SetDevicePower(db, device, models.EnumPowerError)
+		return jsonErr
+	}
+
+	return models.SetDevicePower(db, device, ReportedPowerState(provider, device))
+}
+
+// Cycle shuts down the `Device` through its provider, waits for `delay` and
+// boots it again, then stores the power state reported by the provider
+func Cycle(db *pg.DB, providers *ProviderRegistry, device *models.Device, delay time.Duration) *models.JSONError {
+	provider, jsonErr := providers.ForDevice(device)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	if jsonErr := provider.Cycle(device.ProviderAddress(), delay); jsonErr != nil {
+		models.SetDevicePower(db, device, models.EnumPowerError)
+		return jsonErr
+	}
+
+	// the device went through the `off` state during the cycle
+	device.SetPowerState(models.EnumPowerOff)
+
+	state := ReportedPowerState(provider, device)
+	if state == models.EnumPowerOn {
+		state = models.EnumPowerBooting
+	}
+
+	return models.SetDevicePower(db, device, state)
+}
+
+// ReportedPowerState asks the `Provider` for the current power state of the
+// `Device`, and returns `unknown` if it cannot tell
+func ReportedPowerState(provider Provider, device *models.Device) models.PowerState {
+	remote, jsonErr := provider.GetDevice(device.ProviderAddress())
+	if jsonErr != nil {
+		return models.EnumPowerUnknown
+	}
+
+	return remote.PowerState
+}
@@ -1,105 +1,34 @@
 package services
 
 import (
-	"encoding/json"
-	"io"
-
-	"net/http"
+	"time"
 
 	"github.com/xiorcale/rubus-api/models"
 )
 
-func request(method, url string, body io.Reader) (*http.Response, *models.JSONError) {
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, models.NewInternalServerError()
-	}
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, models.NewInternalServerError()
-	}
-
-	return res, nil
-}
-
-// GetDevice adds a new Rubus `Device` into the database
-func GetDevice(port string) (*models.Device, *models.JSONError) {
-	url := "http://rubus_provider:1080/device/" + port
-
-	res, jsonErr := request("GET", url, nil)
-	if jsonErr != nil {
-		return nil, jsonErr
-	}
-
-	if res.StatusCode == http.StatusNotFound {
-		return nil, &models.JSONError{
-			Status: http.StatusNotFound,
-			Error:  "device not found",
-		}
-	}
-
-	device := models.Device{}
-	if err := json.NewDecoder(res.Body).Decode(&device); err != nil {
-		return nil, models.NewInternalServerError()
-	}
-	defer res.Body.Close()
-
-	return &device, nil
-}
-
-// GetAllDevices add all the Rubus `Device` into the database
-func GetAllDevices() (*[]models.Device, *models.JSONError) {
-	url := "http://rubus_provider:1080/device"
-
-	res, jsonErr := request("GET", url, nil)
-	if jsonErr != nil {
-		return nil, jsonErr
-	}
-
-	devices := []models.Device{}
-	if err := json.NewDecoder(res.Body).Decode(&devices); err != nil {
-		return nil, models.NewInternalServerError()
-	}
-	defer res.Body.Close()
-
-	return &devices, nil
+// Capabilities describes the operations supported by a `Provider`
+type Capabilities struct {
+	Discovery bool `json:"discovery"`
+	PowerOn   bool `json:"powerOn"`
+	PowerOff  bool `json:"powerOff"`
+	Cycle     bool `json:"cycle"`
 }
 
-// PowerDeviceOn boots the `Device` on the given `port`
-func PowerDeviceOn(port string) *models.JSONError {
-	url := "http://rubus_provider:1080/device/" + port + "/on"
-
-	res, jsonErr := request("POST", url, nil)
-	if jsonErr != nil {
-		return jsonErr
-	}
-
-	if res.StatusCode == http.StatusNotFound {
-		return &models.JSONError{
-			Status: http.StatusNotFound,
-			Error:  "device not found",
-		}
-	}
-
-	return nil
-}
-
-// PowerDeviceOff shuts down the `Device` on the given `port`
-func PowerDeviceOff(port string) *models.JSONError {
-	url := "http://rubus_provider:1080/device/" + port + "/off"
-
-	res, jsonErr := request("POST", url, nil)
-	if jsonErr != nil {
-		return jsonErr
-	}
-
-	if res.StatusCode == http.StatusNotFound {
-		return &models.JSONError{
-			Status: http.StatusNotFound,
-			Error:  "device not found",
-		}
-	}
-
-	return nil
+// Provider abstracts the vendor specific logic used to manage the devices
+// (i.e. a PoE switch). Each implementation is responsible for translating
+// these calls into its own back end.
+type Provider interface {
+	// GetAllDevices returns all the `Device` the provider can manage
+	GetAllDevices() (*[]models.Device, *models.JSONError)
+	// GetDevice returns the `Device` on the given `port`
+	GetDevice(port string) (*models.Device, *models.JSONError)
+	// PowerOn boots the `Device` on the given `port`
+	PowerOn(port string) *models.JSONError
+	// PowerOff shuts down the `Device` on the given `port`
+	PowerOff(port string) *models.JSONError
+	// Cycle shuts down the `Device` on the given `port`, waits for `delay`
+	// and boots it again
+	Cycle(port string, delay time.Duration) *models.JSONError
+	// Capabilities returns the operations supported by the provider
+	Capabilities() Capabilities
 }
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/xiorcale/rubus-api/models"
+	"gopkg.in/ini.v1"
+)
+
+// WaitQueue grants the free devices to the `User` waiting for them, in FIFO
+// order. It runs periodically, and whenever a device is released.
+type WaitQueue struct {
+	DB       *pg.DB
+	Cfg      *ini.File
+	Jobs     *JobRunner
+	Interval time.Duration
+
+	mu sync.Mutex
+}
+
+// NewWaitQueue returns a `WaitQueue` which runs every `interval` once started
+func NewWaitQueue(db *pg.DB, cfg *ini.File, jobs *JobRunner, interval time.Duration) *WaitQueue {
+	return &WaitQueue{
+		DB:       db,
+		Cfg:      cfg,
+		Jobs:     jobs,
+		Interval: interval,
+	}
+}
+
+// Start runs the queue in the background. A non positive interval disables
+// the periodic run, the devices are then only granted when they are released.
+func (q *WaitQueue) Start() {
+	if q.Interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(q.Interval)
+		defer ticker.Stop()
+
+		for {
+			q.Process()
+			<-ticker.C
+		}
+	}()
+}
+
+// Process grants every free `Device` to the oldest waiting `QueueEntry` it
+// matches. A device is free when it has no owner, is plugged, and no job is
+// running on it.
+func (q *WaitQueue) Process() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries, jsonErr := models.GetWaitingQueueEntries(q.DB)
+	if jsonErr != nil {
+		log.Printf("Process wait queue: %s", jsonErr.Error)
+		return
+	}
+	if len(*entries) == 0 {
+		return
+	}
+
+	devices, jsonErr := models.GetAllDevices(q.DB)
+	if jsonErr != nil {
+		log.Printf("Process wait queue: %s", jsonErr.Error)
+		return
+	}
+
+	granted := map[int64]bool{}
+	for i := range *devices {
+		device := &(*devices)[i]
+		if device.Owner != nil || device.IsMissing || q.Jobs.Busy(device.ID) {
+			continue
+		}
+
+		for j := range *entries {
+			entry := &(*entries)[j]
+			if granted[entry.ID] || !entry.Matches(device) {
+				continue
+			}
+
+			duration, err := time.ParseDuration(entry.Duration)
+			if err != nil {
+				log.Printf("Queue entry %d: duration is not valid.", entry.ID)
+				continue
+			}
+
+			quota, jsonErr := RoleQuota(q.DB, q.Cfg, entry.UserID)
+			if jsonErr != nil {
+				log.Printf("Queue entry %d: %s", entry.ID, jsonErr.Error)
+				continue
+			}
+
+			// the device may be booked by someone else in the meantime
+			now := time.Now()
+			if models.CheckReservations(q.DB, []int64{device.ID}, now, now.Add(duration), entry.UserID) != nil {
+				continue
+			}
+
+			if jsonErr := models.GrantDevice(q.DB, device, entry.UserID, duration, quota); jsonErr != nil {
+				// the user may have reached their quota in the meantime
+				if jsonErr.Status == http.StatusForbidden {
+					continue
+				}
+				log.Printf("Grant device %d to queue entry %d: %s", device.ID, entry.ID, jsonErr.Error)
+				break
+			}
+
+			granted[entry.ID] = true
+			if jsonErr := models.GrantQueueEntry(q.DB, entry, device.ID); jsonErr != nil {
+				log.Printf("Grant device %d to queue entry %d: %s", device.ID, entry.ID, jsonErr.Error)
+			}
+			Notify(q.DB, entry.UserID, models.EnumNotificationDeviceGranted, device.ID,
+				fmt.Sprintf("device %d has been granted to you until %s.", device.ID, device.LeaseExpiresAt.Format(time.RFC3339)))
+			break
+		}
+	}
+}
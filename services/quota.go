@@ -0,0 +1,67 @@
+package services
+
+import (
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/xiorcale/rubus-api/models"
+	"gopkg.in/ini.v1"
+)
+
+// ReadQuota returns the `Quota` of the given `role`, described by the
+// `[quota.<role>]` section of the configuration, whose missing keys are
+// inherited from the `[quota]` section
+func ReadQuota(cfg *ini.File, role models.Role) models.Quota {
+	section := cfg.Section("quota." + string(role))
+
+	return models.Quota{
+		MaxDevices:      section.Key("max_devices").MustInt(0),
+		MaxHoursPerWeek: section.Key("max_hours_per_week").MustInt(0),
+	}
+}
+
+// ReadSnapshotQuota returns the `SnapshotQuota` of every user, described by
+// the `[snapshot]` section of the configuration
+func ReadSnapshotQuota(cfg *ini.File) models.SnapshotQuota {
+	section := cfg.Section("snapshot")
+
+	return models.SnapshotQuota{
+		MaxCount: section.Key("max_per_user").MustInt(0),
+		MaxSize:  section.Key("max_size_per_user").MustInt64(0) << 20,
+	}
+}
+
+// GetQuotaUsage returns the `Quota` of the `User` with the given `uid`, along
+// with its usage
+func GetQuotaUsage(db *pg.DB, cfg *ini.File, uid int64) (*models.QuotaUsage, *models.JSONError) {
+	user, jsonErr := models.GetUser(db, uid)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	return models.GetQuotaUsage(db, uid, user.Quota(ReadQuota(cfg, user.Role)))
+}
+
+// RoleQuota returns the `Quota` of the role of the `User` with the given
+// `uid`, on which the user's overrides are applied when acquiring devices
+func RoleQuota(db *pg.DB, cfg *ini.File, uid int64) (*models.Quota, *models.JSONError) {
+	user, jsonErr := models.GetUser(db, uid)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	quota := ReadQuota(cfg, user.Role)
+	return &quota, nil
+}
+
+// CheckQuota makes sure the `User` with the given `uid` can own `devices`
+// more devices, and extend their leases by `leased` in total, without
+// exceeding their `Quota`
+func CheckQuota(db *pg.DB, cfg *ini.File, uid int64, devices int, leased time.Duration) *models.JSONError {
+	usage, jsonErr := GetQuotaUsage(db, cfg, uid)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	return usage.Check(devices, leased)
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/xiorcale/rubus-api/models"
+)
+
+// DefaultProviderName is the name of the provider described by the
+// `[provider]` section of the configuration file
+const DefaultProviderName = "default"
+
+// ProviderInfo describes a `Provider` registered in a `ProviderRegistry`
+type ProviderInfo struct {
+	Name         string       `json:"name" example:"default"`
+	BaseURL      string       `json:"baseUrl" example:"http://rubus_provider:1080"`
+	Static       bool         `json:"static"`
+	Capabilities Capabilities `json:"capabilities"`
+}
+
+type registryEntry struct {
+	provider Provider
+	info     ProviderInfo
+}
+
+// ProviderRegistry holds all the named `Provider` known by Rubus, so that
+// each `Device` can be managed by the provider it is plugged into
+type ProviderRegistry struct {
+	mu      sync.RWMutex
+	entries map[string]registryEntry
+}
+
+// NewProviderRegistry returns an empty `ProviderRegistry`
+func NewProviderRegistry() *ProviderRegistry {
+	return &ProviderRegistry{entries: map[string]registryEntry{}}
+}
+
+// Register adds the `Provider` under the given `name`, replacing any provider
+// previously registered with the same name. Static providers come from the
+// configuration file and cannot be unregistered through the API.
+func (r *ProviderRegistry) Register(name, baseURL string, static bool, p Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries[name] = registryEntry{
+		provider: p,
+		info: ProviderInfo{
+			Name:         name,
+			BaseURL:      baseURL,
+			Static:       static,
+			Capabilities: p.Capabilities(),
+		},
+	}
+}
+
+// RegisterProvider builds an `HTTPProvider` for a `Provider` registered by an
+// administrator, using `defaults` for the settings it does not define
+func (r *ProviderRegistry) RegisterProvider(defaults HTTPProviderConfig, provider *models.Provider) error {
+	cfg := defaults
+	cfg.BaseURL = provider.BaseURL
+	cfg.Token = provider.Token
+
+	p, err := NewHTTPProvider(cfg)
+	if err != nil {
+		return err
+	}
+
+	r.Register(provider.Name, cfg.BaseURL, false, p)
+	return nil
+}
+
+// Unregister removes the `Provider` with the given `name`
+func (r *ProviderRegistry) Unregister(name string) *models.JSONError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[name]
+	if !ok {
+		return &models.JSONError{
+			Status: http.StatusNotFound,
+			Error:  "provider does not exist.",
+		}
+	}
+
+	if entry.info.Static {
+		return &models.JSONError{
+			Status: http.StatusBadRequest,
+			Error:  "provider is defined in the configuration file.",
+		}
+	}
+
+	delete(r.entries, name)
+	return nil
+}
+
+// Get returns the `Provider` registered under the given `name`
+func (r *ProviderRegistry) Get(name string) (Provider, *models.JSONError) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.entries[name]
+	if !ok {
+		return nil, &models.JSONError{
+			Status: http.StatusNotFound,
+			Error:  "provider does not exist.",
+		}
+	}
+
+	return entry.provider, nil
+}
+
+// ForDevice returns the `Provider` the given `Device` is plugged into
+func (r *ProviderRegistry) ForDevice(device *models.Device) (Provider, *models.JSONError) {
+	p, jsonErr := r.Get(device.Provider)
+	if jsonErr != nil {
+		return nil, &models.JSONError{
+			Status: http.StatusServiceUnavailable,
+			Error:  "the device's provider is not registered.",
+		}
+	}
+
+	return p, nil
+}
+
+// List returns the description of every registered `Provider`, sorted by name
+func (r *ProviderRegistry) List() []ProviderInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	infos := make([]ProviderInfo, 0, len(r.entries))
+	for _, entry := range r.entries {
+		infos = append(infos, entry.info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	return infos
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/xiorcale/rubus-api/models"
+	"gopkg.in/ini.v1"
+)
+
+// ReservationScheduler periodically starts the `Reservation` whose window has
+// begun, by acquiring their devices for the reserving `User` until the end of
+// the window, and completes the ones whose window is over
+type ReservationScheduler struct {
+	DB       *pg.DB
+	Cfg      *ini.File
+	Interval time.Duration
+}
+
+// NewReservationScheduler returns a `ReservationScheduler` which runs every
+// `interval` once started, and reads the quotas from the given configuration
+func NewReservationScheduler(db *pg.DB, cfg *ini.File, interval time.Duration) *ReservationScheduler {
+	return &ReservationScheduler{
+		DB:       db,
+		Cfg:      cfg,
+		Interval: interval,
+	}
+}
+
+// Start runs the scheduler in the background. A non positive interval
+// disables it.
+func (s *ReservationScheduler) Start() {
+	if s.Interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+
+		for {
+			s.Schedule()
+			<-ticker.C
+		}
+	}()
+}
+
+// Schedule starts the due reservations and completes the ended ones
+func (s *ReservationScheduler) Schedule() {
+	if jsonErr := models.CompleteEndedReservations(s.DB); jsonErr != nil {
+		log.Printf("Complete reservations: %s", jsonErr.Error)
+	}
+
+	reservations, jsonErr := models.GetDueReservations(s.DB)
+	if jsonErr != nil {
+		log.Printf("Start reservations: %s", jsonErr.Error)
+		return
+	}
+
+	for _, reservation := range *reservations {
+		if jsonErr := s.start(&reservation); jsonErr != nil {
+			log.Printf("Start reservation %d: %s", reservation.ID, jsonErr.Error)
+		}
+	}
+}
+
+// start acquires the devices of the `Reservation` for the reserving `User`,
+// within its quota. The devices which are still owned by another user, or
+// which would exceed the quota, are skipped and reported in the `Error` of
+// the reservation, which fails if none could be acquired.
+func (s *ReservationScheduler) start(reservation *models.Reservation) *models.JSONError {
+	duration := time.Until(reservation.EndsAt)
+	failures := []string{}
+
+	quota, jsonErr := RoleQuota(s.DB, s.Cfg, reservation.UserID)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	for _, deviceID := range reservation.DeviceIDs {
+		device, jsonErr := models.GetDevice(s.DB, deviceID)
+		if jsonErr != nil {
+			failures = append(failures, fmt.Sprintf("device %d: %s", deviceID, jsonErr.Error))
+			continue
+		}
+
+		// the user keeps the lease of a device it already owns, the
+		// reservation only protecting the device from the other users
+		if device.Owner != nil && *device.Owner == reservation.UserID {
+			continue
+		}
+		if device.Owner != nil {
+			failures = append(failures, fmt.Sprintf("device %d: still owned by another user.", deviceID))
+			continue
+		}
+
+		if jsonErr := models.GrantDevice(s.DB, device, reservation.UserID, duration, quota); jsonErr != nil {
+			failures = append(failures, fmt.Sprintf("device %d: %s", deviceID, jsonErr.Error))
+		}
+	}
+
+	reservation.Status = models.EnumReservationActive
+	if len(failures) == len(reservation.DeviceIDs) {
+		reservation.Status = models.EnumReservationFailed
+	}
+	reservation.Error = strings.Join(failures, " ")
+
+	return models.UpdateReservationStatus(s.DB, reservation)
+}
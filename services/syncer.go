@@ -0,0 +1,198 @@
+package services
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/xiorcale/rubus-api/models"
+)
+
+// SyncError describes a provider which could not be synchronized
+type SyncError struct {
+	Provider string `json:"provider" example:"default"`
+	Error    string `json:"error" example:"provider is unreachable"`
+}
+
+// SyncReport describes the drift found between the devices cached in the
+// database and the state reported by the providers during the last sync
+type SyncReport struct {
+	LastSync time.Time       `json:"lastSync"`
+	Updated  []models.Device `json:"updated"`
+	Missing  []models.Device `json:"missing"`
+	New      []models.Device `json:"new"`
+	Errors   []SyncError     `json:"errors"`
+}
+
+// Syncer periodically reconciles the devices stored in the database with the
+// state reported by their provider. A `Device` which has just been turned on
+// is considered `booting` during `BootDuration`.
+type Syncer struct {
+	DB           *pg.DB
+	Providers    *ProviderRegistry
+	Interval     time.Duration
+	BootDuration time.Duration
+
+	mu     sync.RWMutex
+	report SyncReport
+}
+
+// NewSyncer returns a `Syncer` which runs every `interval` once started
+func NewSyncer(db *pg.DB, providers *ProviderRegistry, interval, bootDuration time.Duration) *Syncer {
+	return &Syncer{
+		DB:           db,
+		Providers:    providers,
+		Interval:     interval,
+		BootDuration: bootDuration,
+	}
+}
+
+// Start runs the synchronization in the background. A non positive interval
+// disables the periodic synchronization.
+func (s *Syncer) Start() {
+	if s.Interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+
+		for {
+			s.Sync()
+			<-ticker.C
+		}
+	}()
+}
+
+// Report returns the result of the last synchronization
+func (s *Syncer) Report() SyncReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.report
+}
+
+// Sync reconciles every registered provider with the database and returns
+// the resulting `SyncReport`
+func (s *Syncer) Sync() SyncReport {
+	report := SyncReport{
+		Updated: []models.Device{},
+		Missing: []models.Device{},
+		New:     []models.Device{},
+		Errors:  []SyncError{},
+	}
+
+	for _, info := range s.Providers.List() {
+		if !info.Capabilities.Discovery {
+			continue
+		}
+
+		if err := s.syncProvider(info.Name, &report); err != nil {
+			log.Printf("Sync provider %s: %s", info.Name, err.Error)
+			report.Errors = append(report.Errors, SyncError{
+				Provider: info.Name,
+				Error:    err.Error,
+			})
+		}
+	}
+
+	report.LastSync = time.Now()
+
+	s.mu.Lock()
+	s.report = report
+	s.mu.Unlock()
+
+	return report
+}
+
+func (s *Syncer) syncProvider(name string, report *SyncReport) *models.JSONError {
+	provider, jsonErr := s.Providers.Get(name)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	remotes, jsonErr := provider.GetAllDevices()
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	devices, jsonErr := models.GetDevicesByProvider(s.DB, name)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	index := indexRemotes(*remotes)
+	matched := map[int]bool{}
+
+	for i := range *devices {
+		device := &(*devices)[i]
+
+		var remote models.Device
+		j, ok := findRemote(index, *remotes, device)
+		if ok {
+			remote = (*remotes)[j]
+			matched[j] = true
+		}
+
+		changedAt := device.PowerStateChangedAt
+		changed := false
+		if !ok {
+			changed = !device.IsMissing
+			device.IsMissing = true
+			changed = device.SetPowerState(models.EnumPowerUnknown) || changed
+			report.Missing = append(report.Missing, *device)
+		} else {
+			changed = device.IsMissing
+			device.IsMissing = false
+			if remote.Hostname != "" && device.Hostname != remote.Hostname {
+				device.Hostname = remote.Hostname
+				changed = true
+			}
+			changed = device.SetPowerState(s.powerState(device, &remote)) || changed
+		}
+
+		if !changed {
+			continue
+		}
+
+		// the device may have been powered through the API in the meantime,
+		// its new state is then left to the next synchronization
+		updated, jsonErr := models.UpdateDeviceState(s.DB, device, changedAt)
+		if jsonErr != nil && jsonErr.Status == http.StatusConflict {
+			log.Printf("Sync device %d: %s", device.ID, jsonErr.Error)
+			continue
+		}
+		if jsonErr != nil {
+			return jsonErr
+		}
+		if ok && updated {
+			report.Updated = append(report.Updated, *device)
+		}
+	}
+
+	// the remaining devices are known by the provider but not registered
+	for j, remote := range *remotes {
+		if matched[j] {
+			continue
+		}
+		remote.Provider = name
+		report.New = append(report.New, remote)
+	}
+
+	return nil
+}
+
+// powerState returns the `PowerState` the `Device` should have given the
+// state reported by its provider
+func (s *Syncer) powerState(device, remote *models.Device) models.PowerState {
+	if remote.PowerState == models.EnumPowerOn &&
+		device.PowerState == models.EnumPowerBooting &&
+		time.Since(device.PowerStateChangedAt) < s.BootDuration {
+		return models.EnumPowerBooting
+	}
+
+	return remote.PowerState
+}
@@ -0,0 +1,326 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/xiorcale/rubus-api/models"
+	"github.com/xiorcale/rubus-api/provisioning"
+)
+
+// SetupDeviceTask returns a `JobTask` which prepares the files and folders
+// necessary for the network boot and deployment of the device with the given
+// `hostname`
+func SetupDeviceTask(p *provisioning.Provisioner, hostname string) JobTask {
+	return func(ctx context.Context, output io.Writer) error {
+		return p.AddDevice(ctx, hostname, output)
+	}
+}
+
+// CreateDeviceTask returns a `JobTask` which prepares the files and folders
+// necessary for the network boot and deployment of the newly added `Device`.
+// If they cannot be prepared, the device is removed from the database.
+func CreateDeviceTask(db *pg.DB, p *provisioning.Provisioner, device *models.Device) JobTask {
+	return func(ctx context.Context, output io.Writer) error {
+		err := p.AddDevice(ctx, device.Hostname, output)
+		if err == nil {
+			return nil
+		}
+
+		if jsonErr := models.DeleteDevice(db, device.ID); jsonErr != nil {
+			fmt.Fprintf(output, "could not remove device %d: %s\n", device.ID, jsonErr.Error)
+		}
+
+		return err
+	}
+}
+
+// DeleteDeviceTask returns a `JobTask` which removes the files and folders
+// used for the network boot and deployment of the `Device`, and then deletes
+// it from the database
+func DeleteDeviceTask(db *pg.DB, p *provisioning.Provisioner, device *models.Device, hostname string) JobTask {
+	return func(ctx context.Context, output io.Writer) error {
+		if err := p.DeleteDevice(ctx, hostname, output); err != nil {
+			return err
+		}
+
+		if jsonErr := models.DeleteDevice(db, device.ID); jsonErr != nil {
+			return errors.New(jsonErr.Error)
+		}
+
+		return nil
+	}
+}
+
+// PowerOnTask returns a `JobTask` which turns the `Device` on
+func PowerOnTask(db *pg.DB, providers *ProviderRegistry, device *models.Device) JobTask {
+	return func(ctx context.Context, output io.Writer) error {
+		if jsonErr := PowerOn(db, providers, device); jsonErr != nil {
+			return errors.New(jsonErr.Error)
+		}
+		fmt.Fprintf(output, "%s: powered on\n", device.Hostname)
+
+		return nil
+	}
+}
+
+// PowerOffTask returns a `JobTask` which turns the `Device` off
+func PowerOffTask(db *pg.DB, providers *ProviderRegistry, device *models.Device) JobTask {
+	return func(ctx context.Context, output io.Writer) error {
+		if jsonErr := PowerOff(db, providers, device); jsonErr != nil {
+			return errors.New(jsonErr.Error)
+		}
+		fmt.Fprintf(output, "%s: powered off\n", device.Hostname)
+
+		return nil
+	}
+}
+
+// RebootTask returns a `JobTask` which power cycles the `Device`, keeping it
+// off for `delay`
+func RebootTask(db *pg.DB, providers *ProviderRegistry, device *models.Device, delay time.Duration) JobTask {
+	return func(ctx context.Context, output io.Writer) error {
+		fmt.Fprintf(output, "%s: power cycle, off for %s\n", device.Hostname, delay)
+		if jsonErr := Cycle(db, providers, device, delay); jsonErr != nil {
+			return errors.New(jsonErr.Error)
+		}
+		fmt.Fprintf(output, "%s: %s\n", device.Hostname, device.PowerState)
+
+		return nil
+	}
+}
+
+// DeployTask returns a `JobTask` which shuts the `Device` down, configures
+// its PXE boot on top of the given `Image` (nil meaning the default one) along
+// with the optional `firstBoot` configuration, and then boots it
+func DeployTask(db *pg.DB, providers *ProviderRegistry, p *provisioning.Provisioner, device *models.Device, image *models.Image, firstBoot *provisioning.FirstBoot) JobTask {
+	return func(ctx context.Context, output io.Writer) error {
+		// the root file system cannot be remounted under a running device
+		if device.PowerState != models.EnumPowerOff {
+			if jsonErr := PowerOff(db, providers, device); jsonErr != nil {
+				return errors.New(jsonErr.Error)
+			}
+		}
+
+		lower := ""
+		var imageID *int64
+		if image != nil {
+			lower = image.Path
+			imageID = &image.ID
+		}
+
+		if err := p.DeployDevice(ctx, device.Hostname, lower, firstBoot, output); err != nil {
+			return err
+		}
+
+		if jsonErr := models.SetDeviceImage(db, device, imageID); jsonErr != nil {
+			return errors.New(jsonErr.Error)
+		}
+
+		if jsonErr := PowerOn(db, providers, device); jsonErr != nil {
+			return errors.New(jsonErr.Error)
+		}
+
+		return nil
+	}
+}
+
+// NewFirstBoot converts the `UserData` sent by a `User` into the first boot
+// configuration of a device, whose default user is `defaultUser`
+func NewFirstBoot(defaultUser string, userData *models.UserData) *provisioning.FirstBoot {
+	firstBoot := &provisioning.FirstBoot{
+		DefaultUser:    defaultUser,
+		AuthorizedKeys: append([]string{}, userData.SSHAuthorizedKeys...),
+		Packages:       userData.Packages,
+		RunCmd:         userData.RunCmd,
+	}
+
+	for _, user := range userData.Users {
+		firstBoot.Users = append(firstBoot.Users, provisioning.FirstBootUser{
+			Name:           user.Name,
+			Groups:         user.Groups,
+			Sudo:           user.Sudo,
+			AuthorizedKeys: user.SSHAuthorizedKeys,
+		})
+	}
+
+	return firstBoot
+}
+
+// DeviceFirstBoot returns the first boot configuration of the `Device`: the
+// given `UserData`, if any, along with the `SSHKey` of the device's owner. It
+// returns nil if there is nothing to configure.
+func DeviceFirstBoot(db *pg.DB, defaultUser string, device *models.Device, userData *models.UserData) (*provisioning.FirstBoot, *models.JSONError) {
+	firstBoot := &provisioning.FirstBoot{DefaultUser: defaultUser}
+	if userData != nil {
+		firstBoot = NewFirstBoot(defaultUser, userData)
+	}
+
+	if device.Owner != nil {
+		keys, jsonErr := models.GetSSHKeysByUser(db, *device.Owner)
+		if jsonErr != nil {
+			return nil, jsonErr
+		}
+		for _, key := range *keys {
+			firstBoot.AuthorizedKeys = append(firstBoot.AuthorizedKeys, key.AuthorizedKey())
+		}
+	}
+
+	if userData == nil && len(firstBoot.AuthorizedKeys) == 0 {
+		return nil, nil
+	}
+
+	return firstBoot, nil
+}
+
+// WipeTask returns a `JobTask` which shuts the `Device` down and resets its
+// overlay to its pristine state, on top of the given `Image` (nil meaning the
+// default one)
+func WipeTask(db *pg.DB, providers *ProviderRegistry, p *provisioning.Provisioner, device *models.Device, image *models.Image) JobTask {
+	return func(ctx context.Context, output io.Writer) error {
+		if device.PowerState != models.EnumPowerOff {
+			if jsonErr := PowerOff(db, providers, device); jsonErr != nil {
+				return errors.New(jsonErr.Error)
+			}
+		}
+
+		lower := ""
+		if image != nil {
+			lower = image.Path
+		}
+
+		return p.ResetDevice(ctx, device.Hostname, lower, output)
+	}
+}
+
+// ReleaseWipeTask returns a `JobTask` which wipes the `Device` like
+// `WipeTask` does, and only then releases it for the given `reason`,
+// explained by the optional `note`. If the wipe fails, the device keeps its
+// owner.
+func ReleaseWipeTask(db *pg.DB, providers *ProviderRegistry, p *provisioning.Provisioner, device *models.Device, image *models.Image, reason models.LeaseEndReason, note string) JobTask {
+	wipe := WipeTask(db, providers, p, device, image)
+
+	return func(ctx context.Context, output io.Writer) error {
+		if err := wipe(ctx, output); err != nil {
+			return err
+		}
+
+		if jsonErr := models.EndDeviceLease(db, device, reason, note); jsonErr != nil {
+			return errors.New(jsonErr.Error)
+		}
+		fmt.Fprintf(output, "%s: released\n", device.Hostname)
+
+		return nil
+	}
+}
+
+// SubmitWipe submits a `Job` wiping the leased `Device` on top of the image it
+// currently runs and then releasing it for the given `reason`, explained by
+// the optional `note`, on behalf of the `User` with the given `requesterID`.
+// The device stays leased, hence unavailable, until the wipe is over.
+func SubmitWipe(jobs *JobRunner, providers *ProviderRegistry, p *provisioning.Provisioner, device *models.Device, reason models.LeaseEndReason, note string, requesterID int64) (*models.Job, *models.JSONError) {
+	task, jsonErr := DeviceReleaseWipeTask(jobs.DB, providers, p, device, reason, note)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	job := &models.Job{
+		Type:        models.EnumJobWipe,
+		DeviceID:    &device.ID,
+		RequesterID: requesterID,
+	}
+
+	if jsonErr := jobs.Submit(job, task); jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	return job, nil
+}
+
+// DeviceReleaseWipeTask returns the `ReleaseWipeTask` of the leased `Device`,
+// on top of the image it currently runs
+func DeviceReleaseWipeTask(db *pg.DB, providers *ProviderRegistry, p *provisioning.Provisioner, device *models.Device, reason models.LeaseEndReason, note string) (JobTask, *models.JSONError) {
+	var image *models.Image
+	if device.ImageID != nil {
+		var jsonErr *models.JSONError
+		if image, jsonErr = models.GetImage(db, *device.ImageID); jsonErr != nil {
+			return nil, jsonErr
+		}
+	}
+
+	return ReleaseWipeTask(db, providers, p, device, image, reason, note), nil
+}
+
+// SnapshotTask returns a `JobTask` which shuts the `Device` down, archives its
+// overlay into the given `Snapshot` and saves it, then boots the device again
+// if it was running. The `Image` is the one deployed on the device (nil
+// meaning the default one).
+func SnapshotTask(db *pg.DB, providers *ProviderRegistry, p *provisioning.Provisioner, device *models.Device, image *models.Image, snapshot *models.Snapshot, quota models.SnapshotQuota) JobTask {
+	return func(ctx context.Context, output io.Writer) error {
+		wasOff := device.PowerState == models.EnumPowerOff
+		if !wasOff {
+			if jsonErr := PowerOff(db, providers, device); jsonErr != nil {
+				return errors.New(jsonErr.Error)
+			}
+		}
+
+		lower := ""
+		if image != nil {
+			lower = image.Path
+		}
+
+		size, err := p.SnapshotDevice(ctx, device.Hostname, lower, snapshot.Path, output)
+		if err != nil {
+			return err
+		}
+
+		// the quota is checked again, since other snapshots may have been
+		// taken in the meantime
+		snapshot.Size = size
+		if jsonErr := models.AddSnapshot(db, snapshot, quota); jsonErr != nil {
+			p.DeleteSnapshot(snapshot.Path)
+			return errors.New(jsonErr.Error)
+		}
+		fmt.Fprintf(output, "%s: snapshot %d created (%d bytes)\n", device.Hostname, snapshot.ID, size)
+
+		if !wasOff {
+			if jsonErr := PowerOn(db, providers, device); jsonErr != nil {
+				return errors.New(jsonErr.Error)
+			}
+		}
+
+		return nil
+	}
+}
+
+// RestoreTask returns a `JobTask` which shuts the `Device` down and replaces
+// its overlay by the given `Snapshot`, on top of the `Image` the snapshot was
+// taken on (nil meaning the default one)
+func RestoreTask(db *pg.DB, providers *ProviderRegistry, p *provisioning.Provisioner, device *models.Device, snapshot *models.Snapshot, image *models.Image) JobTask {
+	return func(ctx context.Context, output io.Writer) error {
+		if device.PowerState != models.EnumPowerOff {
+			if jsonErr := PowerOff(db, providers, device); jsonErr != nil {
+				return errors.New(jsonErr.Error)
+			}
+		}
+
+		lower := ""
+		if image != nil {
+			lower = image.Path
+		}
+
+		if err := p.RestoreDevice(ctx, device.Hostname, lower, snapshot.Path, output); err != nil {
+			return err
+		}
+
+		if jsonErr := models.SetDeviceImage(db, device, snapshot.ImageID); jsonErr != nil {
+			return errors.New(jsonErr.Error)
+		}
+
+		return nil
+	}
+}
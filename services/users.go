@@ -0,0 +1,53 @@
+package services
+
+import (
+	"log"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/xiorcale/rubus-api/models"
+	"github.com/xiorcale/rubus-api/provisioning"
+)
+
+// DeleteUser removes the `User` with the given `uid`, along with their SSH
+// keys, notifications and snapshots. The devices the user owned are shut down
+// and granted to the users waiting in the `queue`.
+func DeleteUser(db *pg.DB, providers *ProviderRegistry, p *provisioning.Provisioner, queue *WaitQueue, uid int64) *models.JSONError {
+	// the devices are shut down while the user still owns them, so that no
+	// other user can acquire them in the meantime
+	owned, jsonErr := models.GetDevicesByOwner(db, uid)
+	if jsonErr != nil {
+		return jsonErr
+	}
+	for i := range *owned {
+		powerOffDevice(db, providers, &(*owned)[i])
+	}
+
+	devices, snapshots, jsonErr := models.DeleteUser(db, uid)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	for _, snapshot := range *snapshots {
+		if err := p.DeleteSnapshot(snapshot.Path); err != nil {
+			log.Printf("Delete snapshot %d of user %d: %s", snapshot.ID, uid, err)
+		}
+	}
+
+	// the released devices are granted to the users waiting for them
+	if len(*devices) > 0 {
+		queue.Process()
+	}
+	return nil
+}
+
+// powerOffDevice shuts the `Device` down, unless it already is, before it is
+// taken from its owner. A failure is only logged, since the device is
+// released anyway.
+func powerOffDevice(db *pg.DB, providers *ProviderRegistry, device *models.Device) {
+	if device.PowerState == models.EnumPowerOff {
+		return
+	}
+	if jsonErr := PowerOff(db, providers, device); jsonErr != nil {
+		log.Printf("Power off device %d: %s", device.ID, jsonErr.Error)
+	}
+}